@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/mxpaul/unfuckup_s3/deletelog"
+	"github.com/mxpaul/unfuckup_s3/generator"
+)
+
+const defaultDeletedOutput = generator.StdinPath // stdout
+
+type deletedLogReader func(src io.Reader, name string, emit func(deletelog.Event)) error
+
+func NewDeleteFilterFromConfigOrDie(config *viper.Viper) deletelog.Filter {
+	return deletelog.Filter{
+		After:      timeFromConfigOrDie(config, "deleted.after"),
+		Before:     timeFromConfigOrDie(config, "deleted.before"),
+		Principals: config.GetStringSlice("deleted.principal"),
+		Buckets:    config.GetStringSlice("deleted.bucket"),
+		KeyPrefix:  config.GetString("deleted.prefix"),
+	}
+}
+
+func readDeleteLogs(paths []string, read deletedLogReader, emit func(deletelog.Event)) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	expanded, err := generator.ExpandInputPaths(paths)
+	if err != nil {
+		return err
+	}
+	for _, path := range expanded {
+		if err := readDeleteLog(path, read, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDeleteLog(path string, read deletedLogReader, emit func(deletelog.Event)) error {
+	raw, err := generator.OpenInput(path)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	src, _, err := generator.Decompress(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer src.Close()
+	return read(src, path, emit)
+}
+
+func deletedRun(cmd *cobra.Command, args []string) {
+	config := viper.GetViper()
+	accessLogs := config.GetStringSlice("deleted.access_log")
+	cloudTrail := config.GetStringSlice("deleted.cloudtrail")
+	if len(accessLogs) == 0 && len(cloudTrail) == 0 {
+		log.Fatalf("no logs given, use --access-log or --cloudtrail")
+	}
+	filter := NewDeleteFilterFromConfigOrDie(config)
+	if len(filter.Buckets) != 1 {
+		log.Fatalf("exactly one --bucket required, output keys carry no bucket")
+	}
+
+	var out io.Writer = os.Stdout
+	if output := config.GetString("deleted.output"); output != defaultDeletedOutput {
+		file, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("output error: %s", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+
+	var dedup deletelog.Dedup
+	var found, written uint64
+	var writeErr error
+	emit := func(event deletelog.Event) {
+		found++
+		if writeErr != nil || !filter.Match(event) || !dedup.First(event) {
+			return
+		}
+		written++
+		_, writeErr = fmt.Fprintln(writer, generator.QuoteId(event.Key))
+	}
+	if err := readDeleteLogs(accessLogs, deletelog.ReadAccessLog, emit); err != nil {
+		log.Fatalf("access log error: %s", err)
+	}
+	if err := readDeleteLogs(cloudTrail, deletelog.ReadCloudTrail, emit); err != nil {
+		log.Fatalf("cloudtrail error: %s", err)
+	}
+	if writeErr == nil {
+		writeErr = writer.Flush()
+	}
+	if writeErr != nil {
+		log.Fatalf("output error: %s", writeErr)
+	}
+	log.Printf("deleted keys found: %d, written after filter and dedup: %d", found, written)
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/mxpaul/unfuckup_s3/generator"
+	"github.com/mxpaul/unfuckup_s3/s3api"
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+// defaultDestination is s3.restore section itself, profiles of s3.destinations
+// are laid over it
+const defaultDestination = "restore"
+
+// Destination is where routed ids are restored to: bucket with its own
+// endpoint, credentials, transfer limits, concurrency, strategy, report and
+// stats
+type Destination struct {
+	Name        string
+	Restorer    *worker.AmazonRestorer
+	Versions    *s3api.VersionRestorer // restore from previous version first, nil to always use backup
+	Copier      *s3api.BucketCopier    // server side copy from backup bucket, nil to download backup
+	Report      *StrategyReport
+	Stat        *Stat
+	MaxParallel int // tasks in workers at once, 0 leaves it to worker pool
+
+	// used by main loop only
+	running int
+	queue   []worker.WorkerTask
+}
+
+// Start takes worker slot for task. When destination has MaxParallel tasks
+// running, task waits in queue and Start returns false
+func (dest *Destination) Start(task worker.WorkerTask) bool {
+	if dest.MaxParallel > 0 && dest.running >= dest.MaxParallel {
+		dest.queue = append(dest.queue, task)
+		return false
+	}
+	dest.running++
+	return true
+}
+
+// Done frees slot of task that left worker, queued task takes it over
+func (dest *Destination) Done() (worker.WorkerTask, bool) {
+	if len(dest.queue) == 0 {
+		dest.running--
+		return worker.WorkerTask{}, false
+	}
+	task := dest.queue[0]
+	dest.queue = dest.queue[1:]
+	return task, true
+}
+
+// Drain empties queue of tasks not started yet
+func (dest *Destination) Drain() []worker.WorkerTask {
+	tasks := dest.queue
+	dest.queue = nil
+	return tasks
+}
+
+// DestinationConfig returns config whose s3.restore is s3.destinations.<name>
+// laid over s3.restore, so profile sets only what differs and destination is
+// built by the same code as s3.restore. Report of other destination gets its
+// name in file name: report.tsv becomes report.eu.tsv
+func DestinationConfig(config *viper.Viper, name string) *viper.Viper {
+	if name == defaultDestination {
+		return config
+	}
+	section := "s3.destinations." + name
+	if !config.IsSet(section) {
+		log.Fatalf("%s: destination is routed to but not configured", section)
+	}
+	profile := config.GetStringMap(section)
+	merged := viper.New()
+	if err := merged.MergeConfigMap(config.AllSettings()); err != nil {
+		log.Fatalf("%s: %s", section, err)
+	}
+	if err := merged.MergeConfigMap(map[string]interface{}{"s3": map[string]interface{}{"restore": profile}}); err != nil {
+		log.Fatalf("%s: %s", section, err)
+	}
+	if _, ok := profile["report"]; !ok {
+		if path := config.GetString("s3.restore.report"); path != "" {
+			ext := filepath.Ext(path)
+			merged.Set("s3.restore.report", fmt.Sprintf("%s.%s%s", strings.TrimSuffix(path, ext), name, ext))
+		}
+	}
+	return merged
+}
+
+type routeConfig struct {
+	Destination string `mapstructure:"destination"`
+	Prefix      string `mapstructure:"prefix"`
+	Regex       string `mapstructure:"regex"`
+	File        string `mapstructure:"file"`
+}
+
+// NewRouterFromConfigOrDie reads s3.routes, without routes every id goes to
+// s3.route_default
+func NewRouterFromConfigOrDie(config *viper.Viper) *worker.Router {
+	var routes []routeConfig
+	if err := config.UnmarshalKey("s3.routes", &routes); err != nil {
+		log.Fatalf("s3.routes: %s", err)
+	}
+	router := &worker.Router{Default: config.GetString("s3.route_default")}
+	for i, rc := range routes {
+		if rc.Destination == "" {
+			log.Fatalf("s3.routes[%d]: destination required", i)
+		}
+		route := worker.Route{Destination: rc.Destination, Prefix: rc.Prefix}
+		if rc.Regex != "" {
+			pattern, err := regexp.Compile(rc.Regex)
+			if err != nil {
+				log.Fatalf("s3.routes[%d].regex: %s", i, err)
+			}
+			route.Pattern = pattern
+		}
+		if rc.File != "" {
+			ids, err := worker.LoadRouteIds(rc.File)
+			if err != nil {
+				log.Fatalf("s3.routes[%d].file: %s", i, err)
+			}
+			route.Ids = ids
+		}
+		router.Routes = append(router.Routes, route)
+	}
+	if len(router.Destinations()) == 0 {
+		log.Fatalf("s3.routes: no destinations, set s3.route_default or add routes")
+	}
+	return router
+}
+
+// InitDestinationsFromConfigOrDie makes every destination router may pick
+func (app *S3APP) InitDestinationsFromConfigOrDie(config *viper.Viper) {
+	app.Router = NewRouterFromConfigOrDie(config)
+	app.Destinations = make(map[string]*Destination)
+	for _, name := range app.Router.Destinations() {
+		destConfig := DestinationConfig(config, name)
+		dest := &Destination{Name: name, MaxParallel: destConfig.GetInt("s3.restore.max_parallel")}
+		if app.FakeHTTPServer != nil {
+			dest.Restorer = app.NewFakeRestorer(destConfig, name)
+		} else {
+			dest.Restorer = NewAmazonRestorerFromConfigOrDie(destConfig)
+		}
+		dest.InitVersionRestorerFromConfigOrDie(destConfig)
+		dest.InitBucketCopierFromConfigOrDie(destConfig)
+		app.Destinations[name] = dest
+		log.Printf("destination %s: %s", name, dest.Restorer.UrlPrefix)
+		if dest.MaxParallel > 0 {
+			log.Printf("destination %s: at most %d tasks at once", name, dest.MaxParallel)
+		}
+	}
+}
+
+// NewStat returns total stat, stats of destinations count into it. Single
+// destination has its strategies counted in total
+func (app *S3APP) NewStat(shard *generator.Shard) *Stat {
+	total := &Stat{Shard: shard}
+	for _, dest := range app.Destinations {
+		dest.Stat = &Stat{Strategy: dest.Report, Shard: shard, Total: total}
+		if len(app.Destinations) == 1 {
+			total.Strategy = dest.Report
+		}
+	}
+	return total
+}
+
+// DumpStat logs total and, with more than one destination, every destination
+func (app *S3APP) DumpStat(stat *Stat, prefix string) {
+	stat.Dump(prefix)
+	if len(app.Destinations) < 2 {
+		return
+	}
+	for _, name := range app.Router.Destinations() {
+		app.Destinations[name].Stat.Dump(fmt.Sprintf("%s[%s]", prefix, name))
+	}
+}
+
+func (app *S3APP) CloseReports() {
+	for _, dest := range app.Destinations {
+		dest.Report.Close()
+	}
+}
+
+// InitBucketCopierFromConfigOrDie enables server side copy when backup is
+// bucket on the same store as restore bucket
+func (dest *Destination) InitBucketCopierFromConfigOrDie(config *viper.Viper) {
+	switch backupType := config.GetString("s3.backup.type"); backupType {
+	case backupTypeHTTP:
+		return
+	case backupTypeS3:
+	default:
+		log.Fatalf("s3.backup.type: unknown backup type %q, expect %s or %s", backupType, backupTypeHTTP, backupTypeS3)
+	}
+	pattern := NewKeyPatternFromConfigOrDie(config)
+	dest.Copier = &s3api.BucketCopier{
+		Source:         NewS3ClientFromConfigOrDie(config, "s3.backup.bucket"),
+		Destination:    NewS3ClientFromConfigOrDie(config, "s3.restore.bucket"),
+		KeyPrefix:      config.GetString("s3.backup.bucket.key_prefix"),
+		SourceKey:      NewKeyTemplateFromConfigOrDie(config, "s3.backup.bucket.key_template", pattern),
+		DestinationKey: NewKeyTemplateFromConfigOrDie(config, "s3.restore.key_template", pattern),
+		PartSize:       config.GetInt64("s3.backup.bucket.part_size"),
+	}
+	log.Printf("destination %s backup: server side copy from bucket %s, download when copy fails", dest.Name, dest.Copier.Source.Bucket)
+}
+
+// InitVersionRestorerFromConfigOrDie enables undelete or copy strategy and
+// report of strategy used per key
+func (dest *Destination) InitVersionRestorerFromConfigOrDie(config *viper.Viper) {
+	strategy, err := s3api.ParseStrategy(config.GetString("s3.restore.strategy"))
+	if err != nil {
+		log.Fatalf("s3.restore.strategy: %s", err)
+	}
+	dest.Report = NewStrategyReportFromConfigOrDie(config)
+	if strategy == s3api.StrategyBackup {
+		return
+	}
+	dest.Versions = &s3api.VersionRestorer{
+		Client:   NewS3ClientFromConfigOrDie(config, "s3.restore.bucket"),
+		Strategy: strategy,
+	}
+	log.Printf("destination %s restore strategy: %s, backup when key has no previous version", dest.Name, strategy)
+}
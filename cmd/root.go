@@ -6,6 +6,10 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/mxpaul/unfuckup_s3/generator"
+	"github.com/mxpaul/unfuckup_s3/s3api"
+	"github.com/mxpaul/unfuckup_s3/worker"
 	//yaml "gopkg.in/yaml.v2"
 )
 
@@ -47,9 +51,17 @@ s3 эмулируется походом в заданный конфигом UR
 		Run: s3Run,
 	}
 
-	s3Cmd.PersistentFlags().StringP("input", "i", defaultInputFile, "input file, list of deleted id, one file id per line")
+	s3Cmd.PersistentFlags().StringArrayP("input", "i", []string{defaultInputFile}, "input file, list of deleted id, one file id per line, may be gzip, zstd, bzip2 or xz compressed. Repeat for several inputs, directory or glob pattern reads all matching files, - reads stdin")
 	s3Cmd.PersistentFlags().Uint64("offset", defaultOffset, "skip this number of file ids in input file")
 	s3Cmd.PersistentFlags().Uint64("limit", defaultLimit, "stop parsing input file after processing this number of lines")
+	s3Cmd.PersistentFlags().Uint64("line-index", 0, "seek to offset by line index of plain input files, built once with byte offset of every N lines and stored as <input>.lidx; 0 disables")
+	s3Cmd.PersistentFlags().String("format", defaultInputFormat, "input format: lines, csv, tsv or jsonl")
+	s3Cmd.PersistentFlags().String("invalid", generator.InvalidAbort, "what to do with bad input line: abort, skip or quarantine (write to s3.generator.invalid.quarantine file)")
+	s3Cmd.PersistentFlags().BoolP("follow", "f", false, "keep reading ids appended to the last input file like tail -F, until signal or follow idle timeout")
+	s3Cmd.PersistentFlags().Duration("follow-idle", 0, "stop following when no new ids came for this long, 0 follows until signal")
+	s3Cmd.PersistentFlags().String("shard", "", "read only ids of shard i/N, e.g. 2/8, every process reads the same input")
+	s3Cmd.PersistentFlags().String("shard-by", generator.ShardByHash, "assign ids to shards by id hash or by line number modulo N")
+	s3Cmd.PersistentFlags().String("inventory", "", "S3 Inventory manifest.json to read keys from instead of input files")
 
 	if err := viper.BindPFlag("s3.input", s3Cmd.PersistentFlags().Lookup("input")); err != nil {
 		log.Fatalf("BindPFlag s3.input error: %s", err)
@@ -60,20 +72,121 @@ s3 эмулируется походом в заданный конфигом UR
 	if err := viper.BindPFlag("s3.generator.limit", s3Cmd.PersistentFlags().Lookup("limit")); err != nil {
 		log.Fatalf("BindPFlag s3.generator.limit error: %s", err)
 	}
+	if err := viper.BindPFlag("s3.generator.line_index_every", s3Cmd.PersistentFlags().Lookup("line-index")); err != nil {
+		log.Fatalf("BindPFlag s3.generator.line_index_every error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.generator.format", s3Cmd.PersistentFlags().Lookup("format")); err != nil {
+		log.Fatalf("BindPFlag s3.generator.format error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.generator.invalid.policy", s3Cmd.PersistentFlags().Lookup("invalid")); err != nil {
+		log.Fatalf("BindPFlag s3.generator.invalid.policy error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.generator.follow.enabled", s3Cmd.PersistentFlags().Lookup("follow")); err != nil {
+		log.Fatalf("BindPFlag s3.generator.follow.enabled error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.generator.follow.idle_timeout", s3Cmd.PersistentFlags().Lookup("follow-idle")); err != nil {
+		log.Fatalf("BindPFlag s3.generator.follow.idle_timeout error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.shard.spec", s3Cmd.PersistentFlags().Lookup("shard")); err != nil {
+		log.Fatalf("BindPFlag s3.shard.spec error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.shard.by", s3Cmd.PersistentFlags().Lookup("shard-by")); err != nil {
+		log.Fatalf("BindPFlag s3.shard.by error: %s", err)
+	}
+	if err := viper.BindPFlag("s3.inventory.manifest", s3Cmd.PersistentFlags().Lookup("inventory")); err != nil {
+		log.Fatalf("BindPFlag s3.inventory.manifest error: %s", err)
+	}
 
 	viper.SetDefault("s3.input", defaultInputFile)
 	viper.SetDefault("s3.generator.offset", defaultOffset)
 	viper.SetDefault("s3.generator.limit", defaultLimit)
 	viper.SetDefault("s3.generator.value_channel_capacity", defaultValueChannelCapacity)
 	viper.SetDefault("s3.generator.error_channel_capacity", defaultErrorChannelCapacity)
+	viper.SetDefault("s3.generator.format", defaultInputFormat)
+	viper.SetDefault("s3.generator.validate", defaultValidators)
+	viper.SetDefault("s3.sql.driver", defaultSQLDriver)
+	viper.SetDefault("s3.sql.page_size", generator.DefaultSQLPageSize)
+	viper.SetDefault("s3.generator.follow.poll_interval", generator.DefaultFollowPoll)
+	viper.SetDefault("s3.generator.checkpoint.interval", defaultCheckpointInterval)
+	viper.SetDefault("s3.generator.dedup.enabled", false)
+	viper.SetDefault("s3.generator.dedup.exact_limit", generator.DefaultDedupExactLimit)
+	viper.SetDefault("s3.generator.dedup.capacity", generator.DefaultDedupCapacity)
+	viper.SetDefault("s3.generator.dedup.false_positive", generator.DefaultDedupFalsePositive)
 	viper.SetDefault("s3.workerpool.max_parallel", defaultMaxParallel)
 	viper.SetDefault("s3.workerpool.input_channel_capacity", defaultMaxParallel)
 	viper.SetDefault("s3.workerpool.output_channel_capacity", defaultMaxParallel)
 	viper.SetDefault("s3.stat.after_lines", defaultStatAfterLines)
 	viper.SetDefault("s3.stat.after_seconds", defaultStatAfterSeconds)
+	viper.SetDefault("s3.retry.max_attempts", defaultRetryMaxAttempts)
+	viper.SetDefault("s3.retry.max_delay_seconds", defaultRetryMaxDelaySeconds)
+	viper.SetDefault("s3.retry.backoff_millis", defaultRetryBackoffMillis)
+	viper.SetDefault("s3.backup.redirect.mode", defaultBackupRedirectMode)
+	viper.SetDefault("s3.backup.redirect.max_hops", defaultRedirectMaxHops)
+	viper.SetDefault("s3.backup.redirect.sensitive_headers", worker.DefaultSensitiveHeaders)
+	viper.SetDefault("s3.restore.redirect.mode", defaultRestoreRedirectMode)
+	viper.SetDefault("s3.restore.redirect.max_hops", defaultRedirectMaxHops)
+	viper.SetDefault("s3.restore.redirect.sensitive_headers", worker.DefaultSensitiveHeaders)
+	for _, section := range []string{"s3.backup.transport", "s3.restore.transport", "s3.versions.transport", "s3.restore.bucket.transport", "s3.backup.bucket.transport"} {
+		viper.SetDefault(section+".idle_conn_timeout", defaultIdleConnTimeout)
+		viper.SetDefault(section+".keep_alive", defaultKeepAlive)
+		viper.SetDefault(section+".dial_timeout", defaultDialTimeout)
+		viper.SetDefault(section+".tls_handshake_timeout", defaultTLSHandshakeTimeout)
+		viper.SetDefault(section+".http2", defaultHTTP2)
+	}
+	for _, section := range []string{"s3.backup", "s3.restore"} {
+		viper.SetDefault(section+".first_byte_timeout", defaultFirstByteTimeout)
+		viper.SetDefault(section+".idle_read_timeout", defaultIdleReadTimeout)
+		viper.SetDefault(section+".stall.min_bytes_per_second", defaultStallMinBytesPerSec)
+		viper.SetDefault(section+".stall.period", defaultStallPeriod)
+	}
+	viper.SetDefault("s3.backup.health.fail_threshold", defaultMirrorFailThreshold)
+	viper.SetDefault("s3.backup.health.cooldown", defaultMirrorCooldown)
+	viper.SetDefault("s3.backup.hedge.min_samples", defaultHedgeMinSamples)
+	viper.SetDefault("s3.versions.timeout", defaultVersionsTimeout)
+	viper.SetDefault("s3.restore.strategy", defaultRestoreStrategy)
+	viper.SetDefault("s3.route_default", defaultDestination)
+	viper.SetDefault("s3.restore.bucket.timeout", defaultVersionsTimeout)
+	viper.SetDefault("s3.backup.type", backupTypeHTTP)
+	viper.SetDefault("s3.backup.bucket.timeout", defaultVersionsTimeout)
+	viper.SetDefault("s3.backup.bucket.part_size", s3api.DefaultCopyPartSize)
+	viper.SetDefault("s3.versions.page_size", defaultVersionsPageSize)
 	viper.SetDefault("s3.fakeserver.use_fake_server", false)
 
 	rootCmd.AddCommand(s3Cmd)
+
+	deletedCmd := &cobra.Command{
+		Use:   "deleted",
+		Short: "extract deleted keys from S3 server access logs and CloudTrail",
+		Long: `Print keys removed by DeleteObject and DeleteObjects from one bucket, one per line.
+
+Logs may be files, directories or glob patterns, plain or compressed.
+Pipe output into restore: unfuckup deleted --bucket src --cloudtrail trail/ | unfuckup s3 -i -
+`,
+		Run: deletedRun,
+	}
+	deletedCmd.Flags().StringArray("access-log", nil, "S3 server access log file, directory or glob, may repeat")
+	deletedCmd.Flags().StringArray("cloudtrail", nil, "CloudTrail log file, directory or glob, may repeat")
+	deletedCmd.Flags().String("after", "", "skip deletes before this RFC3339 time")
+	deletedCmd.Flags().String("before", "", "skip deletes at or after this RFC3339 time")
+	deletedCmd.Flags().StringArray("principal", nil, "only deletes made by this ARN, principal id or user name, may repeat")
+	deletedCmd.Flags().StringArray("bucket", nil, "bucket to take deletes from, required: output keys carry no bucket")
+	deletedCmd.Flags().String("prefix", "", "only keys with this prefix")
+	deletedCmd.Flags().StringP("output", "o", defaultDeletedOutput, "output file, - for stdout")
+	for key, flag := range map[string]string{
+		"deleted.access_log": "access-log",
+		"deleted.cloudtrail": "cloudtrail",
+		"deleted.after":      "after",
+		"deleted.before":     "before",
+		"deleted.principal":  "principal",
+		"deleted.bucket":     "bucket",
+		"deleted.prefix":     "prefix",
+		"deleted.output":     "output",
+	} {
+		if err := viper.BindPFlag(key, deletedCmd.Flags().Lookup(flag)); err != nil {
+			log.Fatalf("BindPFlag %s error: %s", key, err)
+		}
+	}
+	rootCmd.AddCommand(deletedCmd)
 }
 
 func initConfigOrDie() {
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,7 +14,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -19,8 +26,10 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/mxpaul/unfuckup_s3/generator"
+	"github.com/mxpaul/unfuckup_s3/s3api"
 	"github.com/mxpaul/unfuckup_s3/worker"
 	"github.com/mxpaul/unfuckup_s3/worker/pool"
+	_ "modernc.org/sqlite" // "sqlite" driver for s3.sql, pure go
 )
 
 const (
@@ -29,28 +38,190 @@ const (
 	defaultLimit                = uint64(1)
 	defaultValueChannelCapacity = uint64(1024)
 	defaultErrorChannelCapacity = uint64(0)
+	defaultInputFormat          = generator.FormatLines
 	defaultMaxParallel          = uint64(100)
 	defaultStatAfterLines       = uint64(100000)
 	defaultStatAfterSeconds     = uint64(60)
+	defaultRetryMaxAttempts     = uint64(3)
+	defaultRetryMaxDelaySeconds = uint64(60)
+	defaultRetryBackoffMillis   = uint64(500)
+	defaultBackupRedirectMode   = worker.RedirectFollow
+	defaultRestoreRedirectMode  = worker.RedirectDeny
+	defaultRedirectMaxHops      = 10
+	defaultIdleConnTimeout      = 90 * time.Second
+	defaultKeepAlive            = 30 * time.Second
+	defaultDialTimeout          = 10 * time.Second
+	defaultTLSHandshakeTimeout  = 10 * time.Second
+	defaultHTTP2                = true
+	defaultFirstByteTimeout     = 60 * time.Second
+	defaultIdleReadTimeout      = 60 * time.Second
+	defaultStallMinBytesPerSec  = uint64(1024)
+	defaultStallPeriod          = 30 * time.Second
+	defaultMirrorFailThreshold  = 3
+	defaultMirrorCooldown       = 30 * time.Second
+	defaultHedgeMinSamples      = 100
+	defaultVersionsTimeout      = 60 * time.Second
+	defaultVersionsPageSize     = 1000
+	defaultRestoreStrategy      = "backup"
+	defaultSQLDriver            = "sqlite"
+	defaultCheckpointInterval   = 5 * time.Second
+	backupTypeHTTP              = "http"
+	backupTypeS3                = "s3"
 )
 
-func IsFileExist(path string) (bool, error) {
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		} else {
-			return false, err
+var defaultValidators = []string{"no_space", "utf8", "max_length"}
+
+// InputPathsFromConfig accepts single path or list of paths, directories and
+// glob patterns, "-" means stdin
+func InputPathsFromConfig(config *viper.Viper) []string {
+	if path, ok := config.Get("s3.input").(string); ok {
+		return []string{path}
+	}
+	return config.GetStringSlice("s3.input")
+}
+
+func timeFromConfigOrDie(config *viper.Viper, key string) time.Time {
+	value := config.GetString(key)
+	if value == "" {
+		return time.Time{}
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("%s: expect RFC3339 time like 2006-01-02T15:04:05Z: %s", key, err)
+	}
+	return parsed
+}
+
+func NewInventoryFilterFromConfigOrDie(config *viper.Viper) generator.InventoryFilter {
+	return generator.InventoryFilter{
+		Prefix:         config.GetString("s3.inventory.prefix"),
+		MinSize:        config.GetInt64("s3.inventory.min_size"),
+		MaxSize:        config.GetInt64("s3.inventory.max_size"),
+		ModifiedAfter:  timeFromConfigOrDie(config, "s3.inventory.modified_after"),
+		ModifiedBefore: timeFromConfigOrDie(config, "s3.inventory.modified_before"),
+	}
+}
+
+// NewS3ClientFromConfigOrDie makes client of S3 compatible API. Requests are
+// signed with SigV4 when access_key_id is set and anonymous otherwise
+func NewS3ClientFromConfigOrDie(config *viper.Viper, section string) *s3api.Client {
+	transport := NewTransportConfigFromConfig(config, section+".transport").NewTransport()
+	transport.TLSClientConfig = NewTLSClientConfigFromConfigOrDie(config, section+".tls")
+	client := &s3api.Client{
+		Endpoint: config.GetString(section + ".endpoint"),
+		Bucket:   config.GetString(section + ".bucket"),
+		Client:   &http.Client{Transport: transport},
+		Timeout:  config.GetDuration(section + ".timeout"),
+	}
+	if client.Endpoint == "" || client.Bucket == "" {
+		log.Fatalf("%s: endpoint and bucket required", section)
+	}
+	if keyId := config.GetString(section + ".access_key_id"); keyId != "" {
+		client.Auth = &s3api.SignerV4{
+			Credentials: s3api.Credentials{
+				AccessKeyId:     keyId,
+				SecretAccessKey: SecretFromConfigOrDie(config, section+".secret_access_key"),
+				SessionToken:    config.GetString(section + ".session_token"),
+			},
+			Region: config.GetString(section + ".region"),
 		}
 	}
+	return client
+}
 
-	return true, nil
+// NewVersionsInputFromConfigOrDie lists keys whose latest version is delete
+// marker in versioned bucket
+func NewVersionsInputFromConfigOrDie(config *viper.Viper) generator.Input {
+	client := NewS3ClientFromConfigOrDie(config, "s3.versions")
+	filter := s3api.DeletedFilter{
+		Prefix: config.GetString("s3.versions.prefix"),
+		After:  timeFromConfigOrDie(config, "s3.versions.deleted_after"),
+		Before: timeFromConfigOrDie(config, "s3.versions.deleted_before"),
+	}
+	reader := s3api.NewDeleteMarkerReader(context.Background(), client, filter)
+	reader.PageSize = config.GetInt("s3.versions.page_size")
+	return reader.Input()
 }
 
-func OpenInputFile(path string) (*os.File, error) {
-	if exist, err := IsFileExist(path); err != nil || !exist {
+// NewSQLInputFromConfigOrDie reads ids from database table, dsn is kept out
+// of input name since it may have password
+func NewSQLInputFromConfigOrDie(config *viper.Viper) generator.Input {
+	driver := config.GetString("s3.sql.driver")
+	db, err := sql.Open(driver, config.GetString("s3.sql.dsn"))
+	if err != nil {
+		log.Fatalf("s3.sql: %s", err)
+	}
+	source := &generator.SQLSource{
+		DB:          db,
+		Table:       config.GetString("s3.sql.table"),
+		IdColumn:    config.GetString("s3.sql.id_column"),
+		KeyColumn:   config.GetString("s3.sql.key_column"),
+		Where:       config.GetString("s3.sql.where"),
+		PageSize:    config.GetInt("s3.sql.page_size"),
+		Placeholder: config.GetString("s3.sql.placeholder"),
+		Cursor:      config.GetString("s3.sql.cursor"),
+		CursorFile:  ShardPath(config, config.GetString("s3.sql.cursor_file")),
+		CloseDB:     true,
+	}
+	return generator.Input{Name: fmt.Sprintf("%s:%s", driver, source.Table), Source: source}
+}
+
+// OpenInputsFromConfig reads delete markers of versioned bucket when
+// s3.versions.bucket is set, database table when s3.sql.dsn is set, S3
+// Inventory when s3.inventory.manifest is set and input files otherwise
+func OpenInputsFromConfig(config *viper.Viper) ([]generator.Input, error) {
+	if config.GetString("s3.versions.bucket") != "" {
+		return []generator.Input{NewVersionsInputFromConfigOrDie(config)}, nil
+	}
+	if config.GetString("s3.sql.dsn") != "" {
+		return []generator.Input{NewSQLInputFromConfigOrDie(config)}, nil
+	}
+	if manifest := config.GetString("s3.inventory.manifest"); manifest != "" {
+		return generator.NewInventoryInputs(manifest, config.GetString("s3.inventory.data_dir"), NewInventoryFilterFromConfigOrDie(config))
+	}
+	paths, err := generator.ExpandInputPaths(InputPathsFromConfig(config))
+	if err != nil {
 		return nil, err
 	}
-	return os.Open(path)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input given")
+	}
+	return generator.NewFileInputs(paths), nil
+}
+
+func NewValidatorsFromConfigOrDie(config *viper.Viper) []generator.Validator {
+	validators, err := generator.ParseValidators(config.GetStringSlice("s3.generator.validate"))
+	if err != nil {
+		log.Fatalf("s3.generator.validate: %s", err)
+	}
+	return validators
+}
+
+// NewKeyPatternFromConfigOrDie compiles s3.key_pattern splitting file id into
+// groups for url and key templates, nil when not set
+func NewKeyPatternFromConfigOrDie(config *viper.Viper) *regexp.Regexp {
+	expr := config.GetString("s3.key_pattern")
+	if expr == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		log.Fatalf("s3.key_pattern: %s", err)
+	}
+	return pattern
+}
+
+// NewKeyTemplateFromConfigOrDie returns nil when key is not set
+func NewKeyTemplateFromConfigOrDie(config *viper.Viper, key string, pattern *regexp.Regexp) *worker.KeyTemplate {
+	template := config.GetString(key)
+	if template == "" {
+		return nil
+	}
+	tmpl, err := worker.ParseKeyTemplate(template, pattern)
+	if err != nil {
+		log.Fatalf("%s: %s", key, err)
+	}
+	return tmpl
 }
 
 func NewGeneratorFromConfig(config *viper.Viper) *generator.Generator {
@@ -59,10 +230,69 @@ func NewGeneratorFromConfig(config *viper.Viper) *generator.Generator {
 		Offset:               config.GetUint64("s3.generator.offset"),
 		ValueChannelCapacity: config.GetUint64("s3.generator.value_channel_capacity"),
 		ErrorChannelCapacity: config.GetUint64("s3.generator.error_channel_capacity"),
+		Format:               config.GetString("s3.generator.format"),
+		Column:               config.GetString("s3.generator.column"),
+		Header:               config.GetBool("s3.generator.header"),
+		FieldPath:            config.GetString("s3.generator.field_path"),
+		Validators:           NewValidatorsFromConfigOrDie(config),
+		IndexEvery:           config.GetUint64("s3.generator.line_index_every"),
+		ReadTimeout:          config.GetDuration("s3.generator.read_timeout"),
+		Follow:               config.GetBool("s3.generator.follow.enabled"),
+		FollowPoll:           config.GetDuration("s3.generator.follow.poll_interval"),
+		FollowIdle:           config.GetDuration("s3.generator.follow.idle_timeout"),
+	}
+	policy, err := generator.ParseInvalidPolicy(config.GetString("s3.generator.invalid.policy"))
+	if err != nil {
+		log.Fatalf("s3.generator.invalid.policy: %s", err)
+	}
+	gen.InvalidPolicy = policy
+	gen.Shard = NewShardFromConfigOrDie(config)
+	if config.GetBool("s3.generator.dedup.enabled") {
+		gen.Dedup = &generator.DedupSet{
+			ExactLimit:    config.GetInt("s3.generator.dedup.exact_limit"),
+			Capacity:      config.GetUint64("s3.generator.dedup.capacity"),
+			FalsePositive: config.GetFloat64("s3.generator.dedup.false_positive"),
+		}
 	}
 	return gen
 }
 
+func NewShardFromConfigOrDie(config *viper.Viper) *generator.Shard {
+	shard, err := generator.ParseShard(config.GetString("s3.shard.spec"), config.GetString("s3.shard.by"))
+	if err != nil {
+		log.Fatalf("s3.shard: %s", err)
+	}
+	return shard
+}
+
+// ShardPath makes per shard name of report file, so shards running on one
+// host do not overwrite each other: report.tsv becomes report.shard-2-of-8.tsv
+func ShardPath(config *viper.Viper, path string) string {
+	shard := NewShardFromConfigOrDie(config)
+	if shard == nil || path == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.shard-%d-of-%d%s", strings.TrimSuffix(path, ext), shard.Index, shard.Count, ext)
+}
+
+// OpenQuarantineFromConfigOrDie opens file for bad lines when invalid line
+// policy is quarantine, nil otherwise. Lines of previous runs are kept
+func OpenQuarantineFromConfigOrDie(config *viper.Viper) *os.File {
+	if config.GetString("s3.generator.invalid.policy") != generator.InvalidQuarantine {
+		return nil
+	}
+	path := ShardPath(config, config.GetString("s3.generator.invalid.quarantine"))
+	if path == "" {
+		log.Fatalf("s3.generator.invalid.quarantine is required for quarantine policy")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatalf("quarantine file: %s", err)
+	}
+	return file
+}
+
 func NewWorkerPoolFromConfig(config *viper.Viper) *pool.WorkerPool {
 	wp := &pool.WorkerPool{
 		InputChannelCapacity:  config.GetUint64("s3.workerpool.input_channel_capacity"),
@@ -73,48 +303,230 @@ func NewWorkerPoolFromConfig(config *viper.Viper) *pool.WorkerPool {
 }
 
 type Stat struct {
-	Input   uint64
-	Success uint64
-	Fail    uint64
-	Retry   uint64
-	Fatal   uint64
+	Input     uint64
+	Success   uint64
+	Fail      uint64
+	Retry     uint64
+	Fatal     uint64
+	Duplicate uint64
+	Probable  uint64 // duplicates found by Bloom filter only, may be false
+	Invalid   uint64
+	FailClass [worker.ErrorClassCount]uint64
+	Strategy  *StrategyReport
+	Shard     *generator.Shard
+	Total     *Stat // destination stat counts into Total as well, nil for total
+}
+
+// add increments counter of s and of every Total above it
+func (s *Stat) add(counter func(*Stat) *uint64) {
+	for ; s != nil; s = s.Total {
+		atomic.AddUint64(counter(s), 1)
+	}
 }
 
 func (s *Stat) AddInput() {
-	atomic.AddUint64(&s.Input, 1)
+	s.add(func(s *Stat) *uint64 { return &s.Input })
 }
 func (s *Stat) AddSuccess() {
-	atomic.AddUint64(&s.Success, 1)
+	s.add(func(s *Stat) *uint64 { return &s.Success })
 }
 func (s *Stat) AddFail() {
-	atomic.AddUint64(&s.Fail, 1)
+	s.add(func(s *Stat) *uint64 { return &s.Fail })
 }
 func (s *Stat) AddRetry() {
-	atomic.AddUint64(&s.Retry, 1)
+	s.add(func(s *Stat) *uint64 { return &s.Retry })
 }
 func (s *Stat) AddFatal() {
-	atomic.AddUint64(&s.Fatal, 1)
+	s.add(func(s *Stat) *uint64 { return &s.Fatal })
+}
+func (s *Stat) AddDuplicate() {
+	s.add(func(s *Stat) *uint64 { return &s.Duplicate })
+}
+func (s *Stat) AddProbableDuplicate() {
+	s.add(func(s *Stat) *uint64 { return &s.Probable })
+}
+func (s *Stat) AddInvalid() {
+	s.add(func(s *Stat) *uint64 { return &s.Invalid })
+}
+func (s *Stat) AddFailClass(class worker.ErrorClass) {
+	s.add(func(s *Stat) *uint64 { return &s.FailClass[class] })
 }
 
 func (s *Stat) String() string {
-	arg := make([]interface{}, 0, 5)
+	arg := make([]interface{}, 0, 8)
 	arg = append(arg,
 		atomic.LoadUint64(&s.Input),
 		atomic.LoadUint64(&s.Success),
 		atomic.LoadUint64(&s.Fail),
 		atomic.LoadUint64(&s.Retry),
 		atomic.LoadUint64(&s.Fatal),
+		atomic.LoadUint64(&s.Duplicate),
+		atomic.LoadUint64(&s.Probable),
+		atomic.LoadUint64(&s.Invalid),
 	)
-	return fmt.Sprintf("Input: %d Success: %d Fail: %d Retry: %d: Fatal: %d", arg...)
+	str := fmt.Sprintf("Input: %d Success: %d Fail: %d Retry: %d: Fatal: %d Duplicate: %d ProbableDuplicate: %d Invalid: %d", arg...)
+	for class := worker.ErrorClassNetwork; class < worker.ErrorClassCount; class++ {
+		str += fmt.Sprintf(" Fail[%s]: %d", class, atomic.LoadUint64(&s.FailClass[class]))
+	}
+	if s.Strategy != nil {
+		str += " " + s.Strategy.String()
+	}
+	return str
 }
 
 func (s *Stat) Dump(prefix string) {
+	if s.Shard != nil {
+		prefix += fmt.Sprintf("[shard %s]", s.Shard)
+	}
 	log.Printf("%s %s", prefix, s.String())
 }
 
+// StrategyReport counts how keys were restored and optionally writes
+// "position id strategy" line per key to file
+type StrategyReport struct {
+	Count [s3api.StrategyCount]uint64
+
+	mu   sync.Mutex
+	file *os.File
+	out  *bufio.Writer
+}
+
+func NewStrategyReportFromConfigOrDie(config *viper.Viper) *StrategyReport {
+	report := &StrategyReport{}
+	path := ShardPath(config, config.GetString("s3.restore.report"))
+	if path == "" {
+		return report
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("s3.restore.report: %s", err)
+	}
+	report.file, report.out = file, bufio.NewWriter(file)
+	return report
+}
+
+func (report *StrategyReport) Add(task worker.WorkerTask, strategy s3api.Strategy) {
+	if report == nil {
+		return
+	}
+	atomic.AddUint64(&report.Count[strategy], 1)
+	if report.out == nil {
+		return
+	}
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	fmt.Fprintf(report.out, "%s\t%s\t%s\n", generator.Position(task.Source, task.Line), task.Id, strategy)
+}
+
+func (report *StrategyReport) String() string {
+	parts := make([]string, 0, s3api.StrategyCount)
+	for strategy := s3api.Strategy(0); strategy < s3api.StrategyCount; strategy++ {
+		parts = append(parts, fmt.Sprintf("Restored[%s]: %d", strategy, atomic.LoadUint64(&report.Count[strategy])))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (report *StrategyReport) Close() {
+	if report == nil || report.file == nil {
+		return
+	}
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	if err := report.out.Flush(); err != nil {
+		log.Printf("[ERR] s3.restore.report write error: %s", err)
+	}
+	report.file.Close()
+}
+
+// Checkpoint saves mark of last finished record to s3.generator.checkpoint.path,
+// or key of last finished row to s3.sql.cursor_file, on timer and when run
+// ends. Next run resumes after it
+type Checkpoint struct {
+	Path     string
+	Cursor   *generator.SQLSource // sql input keeps its own cursor instead of Path
+	Progress *generator.Progress  // nil when checkpoint is off
+	ticker   *time.Ticker
+	saved    generator.Mark
+}
+
+// NewCheckpointFromConfigOrDie makes generator follow records till they are
+// finished and resume after saved checkpoint, call it before generator starts
+func NewCheckpointFromConfigOrDie(config *viper.Viper, gen *generator.Generator, inputs []generator.Input) *Checkpoint {
+	checkpoint := &Checkpoint{Path: ShardPath(config, config.GetString("s3.generator.checkpoint.path"))}
+	for _, input := range inputs {
+		if source, ok := input.Source.(*generator.SQLSource); ok && source.CursorFile != "" {
+			checkpoint.Cursor = source
+		}
+	}
+	if checkpoint.Cursor != nil && checkpoint.Path != "" {
+		log.Fatalf("s3.generator.checkpoint.path: sql input resumes by s3.sql.cursor_file, set only one")
+	}
+	if checkpoint.Path == "" && checkpoint.Cursor == nil {
+		return checkpoint
+	}
+	if checkpoint.Path != "" {
+		mark, err := generator.LoadCheckpoint(checkpoint.Path)
+		if err != nil {
+			log.Fatalf("s3.generator.checkpoint.path: %s", err)
+		}
+		if mark != nil {
+			log.Printf("checkpoint %s: resume after %s, offset ignored", checkpoint.Path, generator.Position(mark.Input, mark.Line))
+		}
+		gen.Resume = mark
+	}
+	checkpoint.Progress = &generator.Progress{}
+	gen.Progress = checkpoint.Progress
+	interval := config.GetDuration("s3.generator.checkpoint.interval")
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	checkpoint.ticker = time.NewTicker(interval)
+	return checkpoint
+}
+
+// C is nil when checkpoint is off, so select never picks it
+func (checkpoint *Checkpoint) C() <-chan time.Time {
+	if checkpoint.ticker == nil {
+		return nil
+	}
+	return checkpoint.ticker.C
+}
+
+func (checkpoint *Checkpoint) Save() {
+	if checkpoint.Progress == nil {
+		return
+	}
+	mark, ok := checkpoint.Progress.Done()
+	if !ok || mark == checkpoint.saved {
+		return
+	}
+	if checkpoint.Cursor != nil {
+		if err := checkpoint.Cursor.SaveCursor(mark); err != nil {
+			log.Printf("[ERR] s3.sql.cursor_file write error: %s", err)
+			return
+		}
+	} else if err := generator.SaveCheckpoint(checkpoint.Path, mark); err != nil {
+		log.Printf("[ERR] s3.generator.checkpoint.path write error: %s", err)
+		return
+	}
+	checkpoint.saved = mark
+}
+
+// Stop saves progress of finished run
+func (checkpoint *Checkpoint) Stop() {
+	if checkpoint.ticker != nil {
+		checkpoint.ticker.Stop()
+	}
+	checkpoint.Save()
+	if checkpoint.saved.Position > 0 {
+		log.Printf("checkpoint: done up to %s", generator.Position(checkpoint.saved.Input, checkpoint.saved.Line))
+	}
+}
+
 type S3APP struct {
 	Backuper       *worker.BackupClient
-	Restorer       *worker.AmazonRestorer
+	Router         *worker.Router
+	Destinations   map[string]*Destination // by name, ids are sent there by Router
 	FakeHTTPServer *httptest.Server
 }
 
@@ -160,59 +572,297 @@ func (app *S3APP) StartFakeServerFromConfig(config *viper.Viper) {
 	mux.Handle("/backup/", ChainMiddleware(backupHandler, middleware...))
 	mux.Handle("/restore/", ChainMiddleware(restoreHandler, middleware...))
 	app.FakeHTTPServer = httptest.NewTLSServer(mux) // Server started
+	// trust fake server certificate but keep transport settings from config
+	fakeTLS := app.FakeHTTPServer.Client().Transport.(*http.Transport).TLSClientConfig
+	backupTransport := NewTransportConfigFromConfig(config, "s3.backup.transport")
+	backupTransport.TLSClientConfig = fakeTLS
 	app.Backuper = &worker.BackupClient{
 		BackupUrlPrefix: fmt.Sprintf("%s/backup/", app.FakeHTTPServer.URL),
-		Client:          app.FakeHTTPServer.Client(),
+		Client:          NewRedirectPolicyFromConfigOrDie(config, "s3.backup.redirect").Client(backupTransport.NewClient()),
+		Timeout:         config.GetDuration("s3.backup.timeout"),
+		Limits:          NewTransferLimitsFromConfig(config, "s3.backup"),
+	}
+}
+
+// NewFakeRestorer uploads to fake server, destination name is url path
+// element after /restore/
+func (app *S3APP) NewFakeRestorer(config *viper.Viper, name string) *worker.AmazonRestorer {
+	restoreTransport := NewTransportConfigFromConfig(config, "s3.restore.transport")
+	restoreTransport.TLSClientConfig = app.FakeHTTPServer.Client().Transport.(*http.Transport).TLSClientConfig
+	return &worker.AmazonRestorer{
+		UrlPrefix:   fmt.Sprintf("%s/restore/%s/", app.FakeHTTPServer.URL, name),
+		KeyTemplate: NewKeyTemplateFromConfigOrDie(config, "s3.restore.key_template", NewKeyPatternFromConfigOrDie(config)),
+		Client:      NewRedirectPolicyFromConfigOrDie(config, "s3.restore.redirect").Client(restoreTransport.NewClient()),
+		Timeout:     config.GetDuration("s3.restore.timeout"),
+		Limits:      NewTransferLimitsFromConfig(config, "s3.restore"),
+	}
+}
+
+// SecretFromConfig reads secret from key itself, from environment variable named
+// in key_env or from file named in key_file, so secrets need not live in config
+func SecretFromConfig(config *viper.Viper, key string) (string, error) {
+	if secret := config.GetString(key); secret != "" {
+		return secret, nil
+	}
+	if name := config.GetString(key + "_env"); name != "" {
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%s_env: environment variable %s not set", key, name)
+		}
+		return secret, nil
+	}
+	if path := config.GetString(key + "_file"); path != "" {
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%s_file: %s", key, err)
+		}
+		return strings.TrimSpace(string(secret)), nil
+	}
+	return "", nil
+}
+
+func SecretFromConfigOrDie(config *viper.Viper, key string) string {
+	secret, err := SecretFromConfig(config, key)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if secret == "" {
+		log.Fatalf("%s not set", key)
+	}
+	return secret
+}
+
+// NewAuthenticatorFromConfigOrDie returns nil when auth type is none
+func NewAuthenticatorFromConfigOrDie(config *viper.Viper, section string, client *http.Client) worker.Authenticator {
+	var auth worker.Authenticator
+	switch authType := config.GetString(section + ".type"); authType {
+	case "", "none":
+		return nil
+	case "bearer":
+		auth = &worker.BearerAuth{Token: SecretFromConfigOrDie(config, section+".token")}
+	case "basic":
+		auth = &worker.BasicAuth{
+			Username: config.GetString(section + ".username"),
+			Password: SecretFromConfigOrDie(config, section+".password"),
+		}
+	case "oauth2":
+		auth = &worker.OAuth2ClientCredentials{
+			TokenUrl:      config.GetString(section + ".token_url"),
+			ClientId:      config.GetString(section + ".client_id"),
+			ClientSecret:  SecretFromConfigOrDie(config, section+".client_secret"),
+			Scopes:        config.GetStringSlice(section + ".scopes"),
+			RefreshBefore: config.GetDuration(section + ".refresh_before"),
+			Client:        client,
+		}
+	case "presign":
+		auth = &worker.PresignAuth{
+			KeyId:   config.GetString(section + ".key_id"),
+			Secret:  SecretFromConfigOrDie(config, section+".secret"),
+			Expires: config.GetDuration(section + ".expires"),
+		}
+	default:
+		log.Fatalf("%s.type: unknown auth type %q, expect none, bearer, basic, oauth2 or presign", section, authType)
 	}
-	app.Restorer = &worker.AmazonRestorer{
-		UrlPrefix: fmt.Sprintf("%s/restore/", app.FakeHTTPServer.URL),
-		Client:    app.FakeHTTPServer.Client(),
+	log.Printf("%s: using %s", section, auth)
+	return auth
+}
+
+func NewTransferLimitsFromConfig(config *viper.Viper, section string) worker.TransferLimits {
+	return worker.TransferLimits{
+		FirstByteTimeout:  config.GetDuration(section + ".first_byte_timeout"),
+		IdleReadTimeout:   config.GetDuration(section + ".idle_read_timeout"),
+		MinBytesPerSecond: config.GetUint64(section + ".stall.min_bytes_per_second"),
+		StallPeriod:       config.GetDuration(section + ".stall.period"),
+	}
+}
+
+// NewTLSClientConfigFromConfigOrDie returns nil when section is empty
+func NewTLSClientConfigFromConfigOrDie(config *viper.Viper, section string) *tls.Config {
+	tlsConfig := worker.TLSConfig{
+		CAFile:                        config.GetString(section + ".ca_file"),
+		CertFile:                      config.GetString(section + ".cert_file"),
+		KeyFile:                       config.GetString(section + ".key_file"),
+		MinVersion:                    config.GetString(section + ".min_version"),
+		InsecureSkipVerifyTestingOnly: config.GetBool(section + ".insecure_skip_verify_testing_only"),
+	}
+	if tlsConfig.IsZero() {
+		return nil
+	}
+	if tlsConfig.InsecureSkipVerifyTestingOnly {
+		log.Printf("[WARN] %s.insecure_skip_verify_testing_only set: server certificate NOT verified", section)
 	}
+	clientConfig, err := tlsConfig.ClientConfig()
+	if err != nil {
+		log.Fatalf("%s: %s", section, err)
+	}
+	return clientConfig
+}
+
+func NewTransportConfigFromConfig(config *viper.Viper, section string) worker.TransportConfig {
+	transport := worker.TransportConfig{
+		MaxIdleConns:          config.GetInt(section + ".max_idle_conns"),
+		MaxIdleConnsPerHost:   config.GetInt(section + ".max_idle_conns_per_host"),
+		MaxConnsPerHost:       config.GetInt(section + ".max_conns_per_host"),
+		IdleConnTimeout:       config.GetDuration(section + ".idle_conn_timeout"),
+		KeepAlive:             config.GetDuration(section + ".keep_alive"),
+		DialTimeout:           config.GetDuration(section + ".dial_timeout"),
+		TLSHandshakeTimeout:   config.GetDuration(section + ".tls_handshake_timeout"),
+		ResponseHeaderTimeout: config.GetDuration(section + ".response_header_timeout"),
+		HTTP2:                 config.GetBool(section + ".http2"),
+	}
+	if transport.MaxIdleConnsPerHost == 0 {
+		// idle pool big enough for every worker to keep its connection
+		transport.MaxIdleConnsPerHost = config.GetInt("s3.workerpool.max_parallel")
+	}
+	return transport
+}
+
+func NewRedirectPolicyFromConfigOrDie(config *viper.Viper, section string) worker.RedirectPolicy {
+	policy := worker.RedirectPolicy{
+		Mode:             config.GetString(section + ".mode"),
+		MaxHops:          config.GetInt(section + ".max_hops"),
+		SensitiveHeaders: config.GetStringSlice(section + ".sensitive_headers"),
+	}
+	if err := policy.Validate(); err != nil {
+		log.Fatalf("%s: %s", section, err)
+	}
+	return policy
 }
 
 func (app *S3APP) InitClientsFromConfigOrDie(config *viper.Viper) {
 	backup_url_prefix := config.GetString("s3.backup.url_prefix")
-	restore_url_prefix := config.GetString("s3.restore.url_prefix")
-	if backup_url_prefix == "" {
-		log.Fatalf("s3.backup.url_prefix not set")
+	backup_url_template := config.GetString("s3.backup.url_template")
+	backup_mirrors := config.GetStringSlice("s3.backup.mirrors")
+	if backup_url_prefix == "" && backup_url_template == "" && len(backup_mirrors) == 0 {
+		log.Fatalf("none of s3.backup.url_prefix, s3.backup.url_template or s3.backup.mirrors set")
 	}
-	if restore_url_prefix == "" {
-		log.Fatalf("s3.restore.url_prefix not set")
+	pattern := NewKeyPatternFromConfigOrDie(config)
+	mirrors, err := worker.NewBackupMirrorTemplates(backup_mirrors, pattern)
+	if err != nil {
+		log.Fatalf("s3.backup.mirrors: %s", err)
 	}
 
-	client := &http.Client{}
+	backupTransport := NewTransportConfigFromConfig(config, "s3.backup.transport")
+	backupTransport.TLSClientConfig = NewTLSClientConfigFromConfigOrDie(config, "s3.backup.tls")
+	backupClient := backupTransport.NewClient()
 
 	app.Backuper = &worker.BackupClient{
 		BackupUrlPrefix: backup_url_prefix,
-		Client:          client,
+		UrlTemplate:     NewKeyTemplateFromConfigOrDie(config, "s3.backup.url_template", pattern),
+		Mirrors:         mirrors,
+		Health: worker.MirrorHealth{
+			FailThreshold: config.GetInt("s3.backup.health.fail_threshold"),
+			Cooldown:      config.GetDuration("s3.backup.health.cooldown"),
+		},
+		HedgePercentile: config.GetFloat64("s3.backup.hedge.percentile"),
+		HedgeMinSamples: config.GetInt("s3.backup.hedge.min_samples"),
+		Auth:            NewAuthenticatorFromConfigOrDie(config, "s3.backup.auth", backupClient),
+		Client:          NewRedirectPolicyFromConfigOrDie(config, "s3.backup.redirect").Client(backupClient),
+		Timeout:         config.GetDuration("s3.backup.timeout"),
+		Limits:          NewTransferLimitsFromConfig(config, "s3.backup"),
 	}
-	app.Restorer = &worker.AmazonRestorer{
-		UrlPrefix: restore_url_prefix,
-		Client:    client,
+}
+
+// NewAmazonRestorerFromConfigOrDie uploads to s3.restore, see DestinationConfig
+// for other destinations
+func NewAmazonRestorerFromConfigOrDie(config *viper.Viper) *worker.AmazonRestorer {
+	restore_url_prefix := config.GetString("s3.restore.url_prefix")
+	if restore_url_prefix == "" {
+		log.Fatalf("s3.restore.url_prefix not set")
 	}
+	restoreTransport := NewTransportConfigFromConfig(config, "s3.restore.transport")
+	restoreTransport.TLSClientConfig = NewTLSClientConfigFromConfigOrDie(config, "s3.restore.tls")
+	return &worker.AmazonRestorer{
+		UrlPrefix:   restore_url_prefix,
+		KeyTemplate: NewKeyTemplateFromConfigOrDie(config, "s3.restore.key_template", NewKeyPatternFromConfigOrDie(config)),
+		Client:      NewRedirectPolicyFromConfigOrDie(config, "s3.restore.redirect").Client(restoreTransport.NewClient()),
+		Timeout:     config.GetDuration("s3.restore.timeout"),
+		Limits:      NewTransferLimitsFromConfig(config, "s3.restore"),
+	}
+}
+
+type RetryPolicy struct {
+	MaxAttempts uint64
+	MaxDelay    time.Duration
+	Backoff     time.Duration
+}
+
+func NewRetryPolicyFromConfig(config *viper.Viper) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: config.GetUint64("s3.retry.max_attempts"),
+		MaxDelay:    time.Duration(config.GetUint64("s3.retry.max_delay_seconds")) * time.Second,
+		Backoff:     time.Duration(config.GetUint64("s3.retry.backoff_millis")) * time.Millisecond,
+	}
+}
+
+// Delay returns pause before next attempt: Retry-After from server if any,
+// exponential backoff for every retryable error otherwise
+func (policy RetryPolicy) Delay(task worker.WorkerTask, err error) time.Duration {
+	delay := worker.RetryAfter(err)
+	if delay == 0 && worker.IsRetryable(err) {
+		delay = policy.Backoff << task.FailCount
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+func DeadLetter(stat *Stat, task worker.WorkerTask, err error) {
+	stat.AddFatal()
+	log.Printf("[ERR][FATAL] Line %s Id %s: %s", generator.Position(task.Source, task.Line), task.Id, err)
 }
 
 func (app *S3APP) FilePrecessCallback() worker.WorkerCallback {
 	return func(task worker.WorkerTask) (err error) {
+		dest := app.Destinations[task.Destination]
+		if dest.Versions != nil {
+			key, err := dest.Restorer.DestinationKey(task.Id)
+			if err != nil {
+				return worker.NewRequestBuildError(worker.PhaseRestore, dest.Versions.Client.BucketUrl(), err)
+			}
+			strategy, err := dest.Versions.Restore(context.Background(), key)
+			if err == nil {
+				dest.Report.Add(task, strategy)
+				return nil
+			}
+			if !errors.Is(err, s3api.ErrNoPriorVersion) {
+				return err
+			}
+		}
+		if dest.Copier != nil {
+			err := dest.Copier.Copy(context.Background(), task.Id)
+			if err == nil {
+				dest.Report.Add(task, s3api.StrategyServerCopy)
+				return nil
+			}
+			if worker.IsRetryable(err) {
+				return err
+			}
+			log.Printf("[ERR][COPY] Line %s Id %s, download backup instead: %s", generator.Position(task.Source, task.Line), task.Id, err)
+		}
 		body, err := app.Backuper.RequestBackupBody(task.Id)
 		if err != nil {
 			return err
 		}
-		return app.Restorer.PutObjectFromReader(task.Id, body)
+		if err := dest.Restorer.PutObjectFromReader(task.Id, body); err != nil {
+			return err
+		}
+		dest.Report.Add(task, s3api.StrategyBackup)
+		return nil
 	}
 }
 
 func s3Run(cmd *cobra.Command, args []string) {
 	log.Print("Start application")
 	config := viper.GetViper()
-	inputFileName := config.GetString("s3.input")
-	log.Printf(`input file: "%s"`, inputFileName)
-
-	inputfd, err := OpenInputFile(inputFileName)
+	inputs, err := OpenInputsFromConfig(config)
 	if err != nil {
-		log.Fatalf("input file %s open error: %s", inputFileName, err)
+		log.Fatalf("input error: %s", err)
+	}
+	for _, input := range inputs {
+		log.Printf(`input file: "%s"`, input.Name)
 	}
-	defer inputfd.Close()
 
 	ctx, genShutdown := context.WithCancel(context.Background())
 
@@ -222,45 +872,123 @@ func s3Run(cmd *cobra.Command, args []string) {
 	} else {
 		app.InitClientsFromConfigOrDie(config)
 	}
+	app.InitDestinationsFromConfigOrDie(config)
+	defer app.CloseReports()
 
 	gen := NewGeneratorFromConfig(config)
-	gen.Init(inputfd)
+	if quarantine := OpenQuarantineFromConfigOrDie(config); quarantine != nil {
+		defer quarantine.Close()
+		gen.Quarantine = quarantine
+	}
+	if gen.Shard != nil {
+		log.Printf("shard %s by %s", gen.Shard, gen.Shard.By)
+	}
+	if gen.Follow {
+		log.Printf("follow input %s, idle timeout %s", inputs[len(inputs)-1].Name, gen.FollowIdle)
+	}
+	checkpoint := NewCheckpointFromConfigOrDie(config, gen, inputs)
+	gen.InitInputs(inputs)
 	gen.Go(ctx)
 
 	pool := NewWorkerPoolFromConfig(config)
 	pool.Go(app.FilePrecessCallback())
+	retry := NewRetryPolicyFromConfig(config)
 
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
-	stat := Stat{}
+	stat := app.NewStat(gen.Shard)
 	go func() {
 		for {
 			time.Sleep(time.Duration(config.GetUint64("s3.stat.after_seconds")) * time.Second)
-			stat.Dump("[STAT][after_seconds]")
+			app.DumpStat(stat, "[STAT][after_seconds]")
 		}
 	}()
 
+	finish := func(task worker.WorkerTask) {
+		if gen.Progress != nil {
+			gen.Progress.Finish(task.Seq)
+		}
+	}
+
 	readCount := uint64(0)
 	var Break bool
 	var defaultWorkResult worker.WorkResult
-	var NoMoreInput bool
+	var NoMoreInput, PoolStopped, Interrupted bool
+	// retries wait here, not in workers. Pool stops when input is over and no
+	// task is in workers or waiting for retry
+	var delayed worker.DelayQueue
+	var inFlight int
+	stopPoolWhenDone := func() {
+		if NoMoreInput && !PoolStopped && inFlight == 0 && delayed.Len() == 0 {
+			PoolStopped = true
+			pool.StopAsync()
+		}
+	}
+	// tasks go to workers from ready queue in select, so loop keeps reading
+	// results while workers are busy. Tasks over limit of destination wait in
+	// its queue. Input is not read while queues hold as many tasks as there
+	// are workers
+	var ready worker.SendQueue
+	queued, maxQueued := 0, config.GetInt("s3.workerpool.max_parallel")
+	if maxQueued < 1 {
+		maxQueued = 1
+	}
+	var values <-chan generator.GeneratorValue
+	errs := gen.ErrorChannel
+	send := func(task worker.WorkerTask) {
+		if app.Destinations[task.Destination].Start(task) {
+			ready.Push(task)
+			return
+		}
+		queued++
+	}
 	for !Break {
+		if NoMoreInput || queued+ready.Len() >= maxQueued {
+			values = nil
+		} else {
+			values = gen.ValueChannel
+		}
 		select {
-		case msg, can_read := <-gen.ValueChannel:
+		case msg, can_read := <-values:
 			if !can_read {
-				if !NoMoreInput {
-					NoMoreInput = true
-					pool.StopAsync()
-				}
+				NoMoreInput = true
+				stopPoolWhenDone()
+				break
+			}
+			task := worker.WorkerTask{Line: msg.Line, Id: msg.Id, Source: msg.Source, Seq: msg.Seq}
+			destination, err := app.Router.Route(msg.Id)
+			if err != nil {
+				stat.AddInput()
+				DeadLetter(stat, task, err)
+				finish(task)
+				break
+			}
+			task.Destination = destination
+			app.Destinations[destination].Stat.AddInput()
+			inFlight++
+			send(task)
+		case msg, can_read := <-errs:
+			if !can_read {
+				errs = nil
+				break
+			}
+			if errors.Is(msg.Err, generator.ErrProbableDuplicate) {
+				stat.AddProbableDuplicate()
+				log.Printf("[DUP][PROBABLE] Line %s: %s", msg.Position(), msg.Err)
 				break
 			}
-			stat.AddInput()
-			pool.InputChannel <- worker.WorkerTask{Line: msg.Line, Id: msg.Id}
-		case msg, can_read := <-gen.ErrorChannel:
-			if can_read {
-				log.Printf("[ERR] Line %d: %s", msg.Line, msg.Err)
+			if errors.Is(msg.Err, generator.ErrDuplicate) {
+				stat.AddDuplicate()
+				log.Printf("[DUP] Line %s: %s", msg.Position(), msg.Err)
+				break
+			}
+			if msg.Skipped {
+				stat.AddInvalid()
+				log.Printf("[SKIP] Line %s: %s", msg.Position(), msg.Err)
+				break
 			}
+			log.Printf("[ERR] Line %s: %s", msg.Position(), msg.Err)
 		case res, open := <-pool.OutputChannel:
 			if !open {
 				Break = true
@@ -270,39 +998,83 @@ func s3Run(cmd *cobra.Command, args []string) {
 				log.Printf("WTF! Default value from open channel!")
 				break
 			}
+			inFlight--
+			if next, ok := app.Destinations[res.Task.Destination].Done(); ok {
+				queued--
+				ready.Push(next)
+			}
+			// destination stat counts into total too
+			dstat := app.Destinations[res.Task.Destination].Stat
 			if res.Err == nil {
-				stat.AddSuccess()
+				dstat.AddSuccess()
+				finish(res.Task)
 			} else {
-				stat.AddFail()
-				if !NoMoreInput {
+				dstat.AddFail()
+				dstat.AddFailClass(worker.ClassOf(res.Err))
+				if !worker.IsRetryable(res.Err) {
+					DeadLetter(dstat, res.Task, res.Err)
+					finish(res.Task)
+				} else if !PoolStopped && !Interrupted {
 					res.Task.FailCount++
-					if res.Task.FailCount < 3 {
-						log.Printf("[ERR][RETRY] Line %d Id %s: %s", res.Task.Line, res.Task.Id, res.Err)
-						stat.AddRetry()
-						pool.InputChannel <- res.Task
+					if uint64(res.Task.FailCount) < retry.MaxAttempts {
+						delay := retry.Delay(res.Task, res.Err)
+						res.Task.NotBefore = time.Now().Add(delay)
+						log.Printf("[ERR][RETRY] Line %s Id %s (delay %s): %s", generator.Position(res.Task.Source, res.Task.Line), res.Task.Id, delay, res.Err)
+						dstat.AddRetry()
+						inFlight++
+						delayed.Push(res.Task)
 					} else {
-						stat.AddFatal()
-						log.Printf("[ERR][FATAL] Line %d Id %s: %s", res.Task.Line, res.Task.Id, res.Err)
+						DeadLetter(dstat, res.Task, res.Err)
+						finish(res.Task)
 					}
 				} else {
-					// FIXME: need support retry after generator input closed
-					stat.AddFatal()
-					log.Printf("[ERR][FATAL] Line %d Id %s: %s", res.Task.Line, res.Task.Id, res.Err)
+					// retry cut off by signal, resumed run reads it again
+					DeadLetter(dstat, res.Task, res.Err)
 				}
 			}
+			stopPoolWhenDone()
 			readCount++
 			if readCount%viper.GetUint64("s3.stat.after_lines") == 0 {
-				stat.Dump("[STAT][after_lines]")
+				app.DumpStat(stat, "[STAT][after_lines]")
+			}
+		case ready.C(pool.InputChannel) <- ready.Next():
+			ready.Sent()
+		case <-checkpoint.C():
+			checkpoint.Save()
+		case fired := <-delayed.C():
+			for _, task := range delayed.Due(fired) {
+				send(task)
 			}
+			stopPoolWhenDone()
 		case GotSignal := <-sigchan:
 			log.Print("")
 			log.Printf("Got signal %v", GotSignal)
+			Interrupted = true
 			genShutdown()
+			for _, task := range delayed.Drain() {
+				inFlight--
+				DeadLetter(app.Destinations[task.Destination].Stat, task, errors.New("retry cancelled by signal"))
+			}
+			for _, dest := range app.Destinations {
+				for _, task := range dest.Drain() {
+					inFlight--
+					queued--
+					DeadLetter(dest.Stat, task, errors.New("cancelled by signal before start"))
+				}
+			}
+			for _, task := range ready.Drain() {
+				inFlight--
+				dest := app.Destinations[task.Destination]
+				dest.Done()
+				DeadLetter(dest.Stat, task, errors.New("cancelled by signal before start"))
+			}
+			stopPoolWhenDone()
 		}
 	}
 	gen.WG.Wait()
+	checkpoint.Stop()
 
-	stat.Dump("[STAT][final]")
+	app.DumpStat(stat, "[STAT][final]")
 	if app.FakeHTTPServer != nil {
 		app.FakeHTTPServer.Close()
 	}
@@ -0,0 +1,98 @@
+package deletelog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// access log fields, see S3 server access log format
+const (
+	accessLogBucket    = 1
+	accessLogTime      = 2
+	accessLogRequester = 4
+	accessLogOperation = 6
+	accessLogKey       = 7
+	accessLogStatus    = 9
+	accessLogMinFields = 10
+)
+
+// AccessLogDeleteOperations are single DeleteObject and every key of
+// DeleteObjects request
+var AccessLogDeleteOperations = []string{"REST.DELETE.OBJECT", "BATCH.DELETE.OBJECT"}
+
+// SplitAccessLogLine splits line by spaces keeping [time] and "quoted" fields
+// whole, brackets and quotes are removed
+func SplitAccessLogLine(line string) ([]string, error) {
+	fields := make([]string, 0, 24)
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			return fields, nil
+		}
+		switch line[0] {
+		case '[', '"':
+			closing := byte('"')
+			if line[0] == '[' {
+				closing = ']'
+			}
+			idx := strings.IndexByte(line[1:], closing)
+			if idx < 0 {
+				return nil, fmt.Errorf("unterminated %q field", closing)
+			}
+			fields = append(fields, line[1:1+idx])
+			line = line[2+idx:]
+		default:
+			idx := strings.IndexByte(line, ' ')
+			if idx < 0 {
+				idx = len(line)
+			}
+			fields = append(fields, line[:idx])
+			line = line[idx:]
+		}
+	}
+}
+
+// ReadAccessLog calls emit for every successful delete in S3 server access log
+func ReadAccessLog(src io.Reader, name string, emit func(Event)) error {
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var line uint64
+	for scanner.Scan() {
+		line++
+		fields, err := SplitAccessLogLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if len(fields) < accessLogMinFields || !contains(AccessLogDeleteOperations, fields[accessLogOperation]) {
+			continue
+		}
+		if status := fields[accessLogStatus]; status != "-" && !strings.HasPrefix(status, "2") {
+			continue
+		}
+		eventTime, err := time.Parse(accessLogTimeLayout, fields[accessLogTime])
+		if err != nil {
+			return fmt.Errorf("%s:%d: time: %w", name, line, err)
+		}
+		key, err := url.PathUnescape(fields[accessLogKey])
+		if err != nil {
+			return fmt.Errorf("%s:%d: key %q: %w", name, line, fields[accessLogKey], err)
+		}
+		emit(Event{
+			Time:      eventTime,
+			Bucket:    fields[accessLogBucket],
+			Key:       key,
+			Principal: []string{fields[accessLogRequester]},
+			Source:    fmt.Sprintf("%s:%d", name, line),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: scan error: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package deletelog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const accessLogSample = `owner bucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 arn:aws:iam::123:user/admin 3E57 REST.GET.VERSIONING - "GET /bucket1?versioning HTTP/1.1" 200 - 113 - 7 - "-" "S3Console/0.4" -
+owner bucket1 [06/Feb/2019:00:01:00 +0000] 192.0.2.3 arn:aws:iam::123:user/admin 3E58 REST.DELETE.OBJECT users/a%20b.jpg "DELETE /bucket1/users/a%20b.jpg HTTP/1.1" 204 - - - 7 - "-" "aws-cli/2" -
+owner bucket1 [06/Feb/2019:00:02:00 +0000] 192.0.2.3 arn:aws:iam::123:user/admin 3E59 REST.DELETE.OBJECT users/denied "DELETE /bucket1/users/denied HTTP/1.1" 403 AccessDenied 243 - 7 - "-" "aws-cli/2" -
+owner bucket1 [06/Feb/2019:00:03:00 +0000] 192.0.2.3 arn:aws:iam::123:user/admin 3E60 REST.POST.MULTI_OBJECT_DELETE - "POST /bucket1?delete HTTP/1.1" 200 - 300 - 7 - "-" "aws-cli/2" -
+owner bucket1 [06/Feb/2019:00:03:00 +0000] 192.0.2.3 arn:aws:iam::123:user/admin 3E60 BATCH.DELETE.OBJECT users/b - - - - - - - - - -
+`
+
+func TestSplitAccessLogLine(t *testing.T) {
+	fields, err := SplitAccessLogLine(`a [06/Feb/2019:00:00:38 +0000]  "GET / HTTP/1.1" "" -`)
+	assert.NoError(t, err, "split")
+	assert.Equal(t, []string{"a", "06/Feb/2019:00:00:38 +0000", "GET / HTTP/1.1", "", "-"}, fields, "fields")
+
+	_, err = SplitAccessLogLine(`a "GET / HTTP/1.1`)
+	assert.Error(t, err, "unterminated quote")
+}
+
+func TestReadAccessLog(t *testing.T) {
+	events := make([]Event, 0)
+	err := ReadAccessLog(strings.NewReader(accessLogSample), "access.log", func(event Event) {
+		events = append(events, event)
+	})
+	assert.NoError(t, err, "read access log")
+	assert.Equal(t, []Event{
+		{
+			Time:      time.Date(2019, 2, 6, 0, 1, 0, 0, time.UTC),
+			Bucket:    "bucket1",
+			Key:       "users/a b.jpg",
+			Principal: []string{"arn:aws:iam::123:user/admin"},
+			Source:    "access.log:2",
+		},
+		{
+			Time:      time.Date(2019, 2, 6, 0, 3, 0, 0, time.UTC),
+			Bucket:    "bucket1",
+			Key:       "users/b",
+			Principal: []string{"arn:aws:iam::123:user/admin"},
+			Source:    "access.log:5",
+		},
+	}, normalizeTimes(events), "successful deletes only")
+}
+
+// normalizeTimes makes parsed fixed zone times comparable with time.UTC
+func normalizeTimes(events []Event) []Event {
+	for i := range events {
+		events[i].Time = events[i].Time.UTC()
+	}
+	return events
+}
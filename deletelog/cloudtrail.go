@@ -0,0 +1,137 @@
+package deletelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const cloudTrailObjectArnPrefix = "arn:aws:s3:::"
+
+type cloudTrailFile struct {
+	Records []cloudTrailRecord `json:"Records"`
+}
+
+type cloudTrailIdentity struct {
+	Arn            string `json:"arn"`
+	PrincipalId    string `json:"principalId"`
+	UserName       string `json:"userName"`
+	SessionContext struct {
+		SessionIssuer struct {
+			Arn      string `json:"arn"`
+			UserName string `json:"userName"`
+		} `json:"sessionIssuer"`
+	} `json:"sessionContext"`
+}
+
+type cloudTrailObject struct {
+	Key string `json:"key"`
+}
+
+// cloudTrailObjects is list of deleted objects, CloudTrail writes single
+// object without array around it
+type cloudTrailObjects []cloudTrailObject
+
+func (objects *cloudTrailObjects) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var object cloudTrailObject
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		*objects = cloudTrailObjects{object}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]cloudTrailObject)(objects))
+}
+
+type cloudTrailRecord struct {
+	EventTime         time.Time          `json:"eventTime"`
+	EventSource       string             `json:"eventSource"`
+	EventName         string             `json:"eventName"`
+	ErrorCode         string             `json:"errorCode"`
+	UserIdentity      cloudTrailIdentity `json:"userIdentity"`
+	RequestParameters struct {
+		BucketName string `json:"bucketName"`
+		Key        string `json:"key"`
+		Delete     struct {
+			Object cloudTrailObjects `json:"object"`
+		} `json:"delete"`
+	} `json:"requestParameters"`
+	Resources []struct {
+		Type string `json:"type"`
+		Arn  string `json:"ARN"`
+	} `json:"resources"`
+}
+
+func (identity cloudTrailIdentity) names() []string {
+	names := make([]string, 0, 5)
+	for _, name := range []string{
+		identity.Arn,
+		identity.PrincipalId,
+		identity.UserName,
+		identity.SessionContext.SessionIssuer.Arn,
+		identity.SessionContext.SessionIssuer.UserName,
+	} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// keys returns bucket and keys of request parameters, or object resources
+// when parameters do not list them
+func (record *cloudTrailRecord) keys() (string, []string) {
+	bucket := record.RequestParameters.BucketName
+	keys := make([]string, 0, 1)
+	if record.RequestParameters.Key != "" {
+		keys = append(keys, record.RequestParameters.Key)
+	}
+	for _, object := range record.RequestParameters.Delete.Object {
+		keys = append(keys, object.Key)
+	}
+	if len(keys) > 0 {
+		return bucket, keys
+	}
+	for _, resource := range record.Resources {
+		if resource.Type != "AWS::S3::Object" || !strings.HasPrefix(resource.Arn, cloudTrailObjectArnPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(resource.Arn, cloudTrailObjectArnPrefix), "/", 2)
+		if len(parts) == 2 && parts[0] == bucket {
+			keys = append(keys, parts[1])
+		}
+	}
+	return bucket, keys
+}
+
+// ReadCloudTrail calls emit for every key of successful DeleteObject and
+// DeleteObjects events in CloudTrail log file
+func ReadCloudTrail(src io.Reader, name string, emit func(Event)) error {
+	var file cloudTrailFile
+	if err := json.NewDecoder(src).Decode(&file); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for i := range file.Records {
+		record := &file.Records[i]
+		if record.EventSource != "s3.amazonaws.com" || record.ErrorCode != "" {
+			continue
+		}
+		if record.EventName != "DeleteObject" && record.EventName != "DeleteObjects" {
+			continue
+		}
+		bucket, keys := record.keys()
+		for _, key := range keys {
+			emit(Event{
+				Time:      record.EventTime,
+				Bucket:    bucket,
+				Key:       key,
+				Principal: record.UserIdentity.names(),
+				Source:    fmt.Sprintf("%s:record %d", name, i+1),
+			})
+		}
+	}
+	return nil
+}
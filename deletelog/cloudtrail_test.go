@@ -0,0 +1,80 @@
+package deletelog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const cloudTrailSample = `{"Records":[
+{"eventTime":"2024-03-01T10:00:00Z","eventSource":"s3.amazonaws.com","eventName":"DeleteObject",
+ "userIdentity":{"arn":"arn:aws:iam::123:user/admin","principalId":"AID1","userName":"admin"},
+ "requestParameters":{"bucketName":"b1","key":"users/1"}},
+{"eventTime":"2024-03-01T10:01:00Z","eventSource":"s3.amazonaws.com","eventName":"DeleteObjects",
+ "userIdentity":{"arn":"arn:aws:sts::123:assumed-role/cleanup/s1","principalId":"ARO1:s1",
+  "sessionContext":{"sessionIssuer":{"arn":"arn:aws:iam::123:role/cleanup","userName":"cleanup"}}},
+ "requestParameters":{"bucketName":"b1","delete":{"Object":[{"Key":"users/2"},{"Key":"users/3"}]}}},
+{"eventTime":"2024-03-01T10:02:00Z","eventSource":"s3.amazonaws.com","eventName":"DeleteObjects",
+ "userIdentity":{"arn":"arn:aws:iam::123:user/admin"},
+ "requestParameters":{"bucketName":"b1","delete":{"Object":{"Key":"users/4"}}}},
+{"eventTime":"2024-03-01T10:03:00Z","eventSource":"s3.amazonaws.com","eventName":"DeleteObjects",
+ "userIdentity":{"arn":"arn:aws:iam::123:user/admin"},
+ "requestParameters":{"bucketName":"b1","delete":{"quiet":"true"}},
+ "resources":[{"type":"AWS::S3::Bucket","ARN":"arn:aws:s3:::b1"},{"type":"AWS::S3::Object","ARN":"arn:aws:s3:::b1/users/5"}]},
+{"eventTime":"2024-03-01T10:04:00Z","eventSource":"s3.amazonaws.com","eventName":"DeleteObject","errorCode":"AccessDenied",
+ "userIdentity":{"arn":"arn:aws:iam::123:user/admin"},"requestParameters":{"bucketName":"b1","key":"users/6"}},
+{"eventTime":"2024-03-01T10:05:00Z","eventSource":"s3.amazonaws.com","eventName":"GetObject",
+ "userIdentity":{"arn":"arn:aws:iam::123:user/admin"},"requestParameters":{"bucketName":"b1","key":"users/7"}}
+]}`
+
+func TestReadCloudTrail(t *testing.T) {
+	keys := make([]string, 0)
+	var first, second Event
+	err := ReadCloudTrail(strings.NewReader(cloudTrailSample), "trail.json", func(event Event) {
+		if len(keys) == 0 {
+			first = event
+		} else if len(keys) == 1 {
+			second = event
+		}
+		keys = append(keys, event.Key)
+	})
+	assert.NoError(t, err, "read cloudtrail")
+	assert.Equal(t, []string{"users/1", "users/2", "users/3", "users/4", "users/5"}, keys, "keys of successful deletes")
+	assert.Equal(t, Event{
+		Time:      time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		Bucket:    "b1",
+		Key:       "users/1",
+		Principal: []string{"arn:aws:iam::123:user/admin", "AID1", "admin"},
+		Source:    "trail.json:record 1",
+	}, first, "DeleteObject event")
+	assert.Equal(t, []string{
+		"arn:aws:sts::123:assumed-role/cleanup/s1", "ARO1:s1", "arn:aws:iam::123:role/cleanup", "cleanup",
+	}, second.Principal, "assumed role known by session issuer")
+
+	err = ReadCloudTrail(strings.NewReader(`{"Records":[`), "broken.json", func(Event) {})
+	assert.Error(t, err, "broken file")
+}
+
+func TestFilter(t *testing.T) {
+	event := Event{
+		Time:      time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		Bucket:    "b1",
+		Key:       "users/1",
+		Principal: []string{"arn:aws:iam::123:user/admin", "admin"},
+	}
+	assert.True(t, Filter{}.Match(event), "empty filter")
+	assert.True(t, Filter{After: event.Time, Before: event.Time.Add(time.Second)}.Match(event), "window start is inclusive")
+	assert.False(t, Filter{Before: event.Time}.Match(event), "window end is exclusive")
+	assert.True(t, Filter{Principals: []string{"someone", "admin"}, Buckets: []string{"b1"}, KeyPrefix: "users/"}.Match(event), "all match")
+	assert.False(t, Filter{Principals: []string{"someone"}}.Match(event), "other principal")
+	assert.False(t, Filter{Buckets: []string{"b2"}}.Match(event), "other bucket")
+	assert.False(t, Filter{KeyPrefix: "tmp/"}.Match(event), "other prefix")
+
+	var dedup Dedup
+	assert.True(t, dedup.First(event), "first")
+	assert.False(t, dedup.First(event), "duplicate")
+	event.Bucket = "b2"
+	assert.True(t, dedup.First(event), "same key in other bucket")
+}
@@ -0,0 +1,74 @@
+// Package deletelog finds keys deleted from S3 in server access logs and
+// CloudTrail event files
+package deletelog
+
+import (
+	"strings"
+	"time"
+)
+
+// Event is one deleted key
+type Event struct {
+	Time      time.Time
+	Bucket    string
+	Key       string
+	Principal []string // all names requester is known by: ARN, principal id, user name
+	Source    string   // file and line event was read from
+}
+
+// Filter selects events. Zero values do not filter
+type Filter struct {
+	After      time.Time
+	Before     time.Time
+	Principals []string
+	Buckets    []string
+	KeyPrefix  string
+}
+
+func (filter Filter) Match(event Event) bool {
+	switch {
+	case !filter.After.IsZero() && event.Time.Before(filter.After):
+		return false
+	case !filter.Before.IsZero() && !event.Time.Before(filter.Before):
+		return false
+	case !strings.HasPrefix(event.Key, filter.KeyPrefix):
+		return false
+	case len(filter.Buckets) > 0 && !contains(filter.Buckets, event.Bucket):
+		return false
+	}
+	if len(filter.Principals) == 0 {
+		return true
+	}
+	for _, principal := range event.Principal {
+		if contains(filter.Principals, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Dedup passes every key once, first event wins
+type Dedup struct {
+	seen map[string]struct{}
+}
+
+func (dedup *Dedup) First(event Event) bool {
+	if dedup.seen == nil {
+		dedup.seen = make(map[string]struct{})
+	}
+	id := event.Bucket + "/" + event.Key
+	if _, ok := dedup.seen[id]; ok {
+		return false
+	}
+	dedup.seen[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,115 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+const cancelReadBufSize = 64 * 1024
+
+var ErrReadTimeout = errors.New("input read timeout")
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// CancelReader reads src in background goroutine, so Read returns as soon as
+// ctx is done or Timeout passes without data, even if src is blocked on pipe,
+// FIFO or stale network mount. Blocked src read is left behind and finishes
+// when src is closed
+type CancelReader struct {
+	Timeout time.Duration // max wait for next chunk of data, 0 waits forever
+
+	ctx     context.Context
+	src     io.Reader
+	request chan []byte
+	result  chan readResult
+	stop    chan struct{}
+	pending []byte
+	err     error
+	started bool
+	waiting bool // request sent, result not yet taken
+}
+
+func NewCancelReader(ctx context.Context, src io.Reader, timeout time.Duration) *CancelReader {
+	return &CancelReader{
+		Timeout: timeout,
+		ctx:     ctx,
+		src:     src,
+		request: make(chan []byte),
+		result:  make(chan readResult, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (r *CancelReader) loop() {
+	for {
+		select {
+		case buf := <-r.request:
+			n, err := r.src.Read(buf)
+			r.result <- readResult{data: buf[:n], err: err}
+			if err != nil {
+				return
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *CancelReader) Read(p []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if !r.started {
+		r.started = true
+		go r.loop()
+	}
+	if !r.waiting {
+		r.request <- make([]byte, cancelReadBufSize)
+		r.waiting = true
+	}
+	var timeout <-chan time.Time
+	if r.Timeout > 0 {
+		timer := time.NewTimer(r.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case res := <-r.result:
+		r.waiting = false
+		r.err = res.err
+		n := copy(p, res.data)
+		r.pending = res.data[n:]
+		if n > 0 {
+			return n, nil
+		}
+		return 0, r.err
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	case <-timeout:
+		// read still pending, next Read waits for the same result
+		return 0, ErrReadTimeout
+	}
+}
+
+// Close stops background goroutine, src is closed by its owner
+func (r *CancelReader) Close() error {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	return nil
+}
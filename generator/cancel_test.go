@@ -0,0 +1,105 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelReaderPassesData(t *testing.T) {
+	data := strings.Repeat("0123456789\n", 20000)
+	reader := NewCancelReader(context.Background(), strings.NewReader(data), time.Second)
+	defer reader.Close()
+	got, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err, "read all")
+	assert.Equal(t, data, string(got), "data as is")
+}
+
+func TestCancelReaderBlockedRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := NewCancelReader(ctx, pr, 0)
+	defer reader.Close()
+
+	go func() {
+		pw.Write([]byte("1\n"))
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	buf := make([]byte, 16)
+	n, err := reader.Read(buf)
+	assert.NoError(t, err, "data before cancel")
+	assert.Equal(t, "1\n", string(buf[:n]), "data before cancel")
+
+	started := time.Now()
+	_, err = reader.Read(buf)
+	assert.ErrorIs(t, err, context.Canceled, "blocked read cancelled")
+	assert.Less(t, int64(time.Since(started)), int64(time.Second), "cancelled promptly")
+	pr.Close()
+}
+
+func TestCancelReaderTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	reader := NewCancelReader(context.Background(), pr, 20*time.Millisecond)
+	defer reader.Close()
+	buf := make([]byte, 16)
+	_, err := reader.Read(buf)
+	assert.ErrorIs(t, err, ErrReadTimeout, "no data in time")
+
+	go pw.Write([]byte("late"))
+	n, err := reader.Read(buf)
+	assert.NoError(t, err, "pending read result taken by next Read")
+	assert.Equal(t, "late", string(buf[:n]), "late data")
+	pw.Close()
+	_, err = reader.Read(buf)
+	assert.Equal(t, io.EOF, err, "eof after writer closed")
+}
+
+func TestGeneratorInterruptedWhileReadBlocked(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	gen := &Generator{}
+	gen.InitInputs([]Input{{Name: "pipe", Open: func() (io.ReadCloser, error) { return pr, nil }}})
+	ctx, cancel := context.WithCancel(context.Background())
+	gen.Go(ctx)
+	// compression detection waits for a few bytes of input
+	go pw.Write([]byte("1\n2\n3\n"))
+	for _, id := range []string{"1", "2", "3"} {
+		value := <-gen.ValueChannel
+		assert.Equal(t, id, value.Id, "value before block")
+	}
+
+	cancel()
+	select {
+	case <-gen.DoneChannel:
+	case <-time.After(time.Second):
+		t.Fatal("generator blocked in read after cancel")
+	}
+	gen.WG.Wait()
+}
+
+func TestTableReadTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	go pw.Write([]byte("1\n2\n3\n"))
+	tests := []TestCase{
+		{Desc: "stalled input is error",
+			Instance: &Generator{ReadTimeout: 50 * time.Millisecond},
+			Inputs:   []Input{{Name: "pipe", Open: func() (io.ReadCloser, error) { return pr, nil }}},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1", Source: "pipe"},
+				GeneratorValue{Line: 2, Id: "2", Source: "pipe"},
+				GeneratorValue{Line: 3, Id: "3", Source: "pipe"},
+			},
+			WantError: []GeneratorError{GeneratorError{Line: 0, Err: fmt.Errorf("scan error: %s", ErrReadTimeout), Source: "pipe"}},
+		},
+	}
+	CheckTestCases(t, tests)
+	pw.Close()
+}
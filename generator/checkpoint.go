@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Mark is where record is in input. Position counts records of all inputs
+// like Offset does, Offset is byte offset where next record starts, -1 when
+// input can not seek there. Key is row key of database source
+type Mark struct {
+	Input    string `json:"input"`
+	Position uint64 `json:"position"`
+	Line     uint64 `json:"line"`
+	Offset   int64  `json:"offset"`
+	Key      string `json:"key,omitempty"`
+}
+
+// Progress follows records from reading till their tasks finish. Done is the
+// last record finished together with every record before it, so run resumed
+// after Done repeats no finished task and skips no unfinished one. Records
+// generator does not emit are finished when read
+type Progress struct {
+	mu       sync.Mutex
+	pending  []Mark // read and not done, by position
+	finished map[uint64]bool
+	done     Mark
+}
+
+func (progress *Progress) Read(mark Mark) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	progress.pending = append(progress.pending, mark)
+}
+
+// Finish marks record at position as finished, in any order
+func (progress *Progress) Finish(position uint64) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if progress.finished == nil {
+		progress.finished = make(map[uint64]bool)
+	}
+	progress.finished[position] = true
+	for len(progress.pending) > 0 && progress.finished[progress.pending[0].Position] {
+		delete(progress.finished, progress.pending[0].Position)
+		progress.done = progress.pending[0]
+		progress.pending = progress.pending[1:]
+	}
+}
+
+// Done is false until first record is finished
+func (progress *Progress) Done() (Mark, bool) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	return progress.done, progress.done.Position > 0
+}
+
+// LoadCheckpoint reads mark saved by SaveCheckpoint, nil when there is no
+// checkpoint file
+func LoadCheckpoint(path string) (*Mark, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	var mark Mark
+	if err := json.Unmarshal(data, &mark); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return &mark, nil
+}
+
+// SaveCheckpoint replaces checkpoint file at once, so stopped run leaves
+// either previous or new mark
+func SaveCheckpoint(path string, mark Mark) error {
+	data, err := json.Marshal(mark)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, append(data, '\n'))
+}
+
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
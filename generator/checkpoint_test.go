@@ -0,0 +1,128 @@
+package generator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressDoneInOrder(t *testing.T) {
+	progress := &Progress{}
+	_, ok := progress.Done()
+	assert.False(t, ok, "nothing done before reading")
+	for position := uint64(1); position <= 4; position++ {
+		progress.Read(Mark{Position: position, Line: position})
+	}
+	progress.Finish(2)
+	_, ok = progress.Done()
+	assert.False(t, ok, "record 1 still running")
+	progress.Finish(1)
+	done, _ := progress.Done()
+	assert.Equal(t, uint64(2), done.Position, "records 1 and 2 finished")
+	progress.Finish(4)
+	done, _ = progress.Done()
+	assert.Equal(t, uint64(2), done.Position, "record 3 still running")
+	progress.Finish(3)
+	done, _ = progress.Done()
+	assert.Equal(t, uint64(4), done.Position, "all finished")
+}
+
+func TestCheckpointFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	mark, err := LoadCheckpoint(path)
+	assert.NoError(t, err, "no checkpoint yet")
+	assert.Nil(t, mark, "no mark without file")
+
+	saved := Mark{Input: "ids.txt", Position: 7, Line: 5, Offset: 42}
+	require.NoError(t, SaveCheckpoint(path, saved), "checkpoint saved")
+	mark, err = LoadCheckpoint(path)
+	if assert.NoError(t, err, "checkpoint loaded") {
+		assert.Equal(t, &saved, mark, "mark saved")
+	}
+}
+
+// readAll runs generator to the end, finishing every value when finish is set
+func readAll(t *testing.T, gen *Generator, inputs []Input, finish func(GeneratorValue) bool) []GeneratorValue {
+	gen.InitInputs(inputs)
+	gen.Go(context.Background())
+	go func() {
+		for range gen.ErrorChannel {
+		}
+	}()
+	var values []GeneratorValue
+	for value := range gen.ValueChannel {
+		values = append(values, value)
+		if finish != nil && finish(value) {
+			gen.Progress.Finish(value.Seq)
+		}
+	}
+	gen.WG.Wait()
+	return values
+}
+
+func TestGeneratorProgressMarks(t *testing.T) {
+	path := writeInput(t, "1\n\n22\n2 2\n333\n4444\n")
+	inputs := NewFileInputs([]string{path})
+	gen := &Generator{Progress: &Progress{}, InvalidPolicy: InvalidSkip}
+	values := readAll(t, gen, inputs, func(value GeneratorValue) bool { return value.Id != "4444" })
+	assert.Equal(t, []uint64{1, 3, 5, 6}, []uint64{values[0].Seq, values[1].Seq, values[2].Seq, values[3].Seq}, "positions of values")
+	done, ok := gen.Progress.Done()
+	if assert.True(t, ok, "records done") {
+		assert.Equal(t, Mark{Input: path, Position: 5, Line: 5, Offset: 14}, done, "empty and bad lines pass, unfinished 4444 holds mark")
+	}
+}
+
+func TestTableGeneratorResume(t *testing.T) {
+	path := writeInput(t, "1\n22\n333\n4444\n55555\n")
+	var gz bytes.Buffer
+	writer := gzip.NewWriter(&gz)
+	writer.Write([]byte("1\n22\n333\n4444\n55555\n"))
+	writer.Close()
+	compressed := StringInput("ids.gz", gz.String())
+	compressed.Path = writeInput(t, gz.String())
+	broken := Input{Name: "read.txt", Open: func() (io.ReadCloser, error) { return nil, errors.New("input before mark opened") }}
+	file := NewFileInputs([]string{path})[0]
+	tests := []TestCase{
+		{Desc: "input of mark seeked to, inputs before it not opened",
+			Instance: &Generator{Resume: &Mark{Input: path, Position: 4, Line: 2, Offset: 5}, Limit: 2},
+			Inputs:   []Input{broken, file},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 3, Id: "333", Source: path},
+				GeneratorValue{Line: 4, Id: "4444", Source: path},
+			},
+		},
+		{Desc: "compressed input read and skipped to position",
+			Instance:  &Generator{Resume: &Mark{Input: "ids.gz", Position: 3, Line: 3, Offset: 9}},
+			Inputs:    []Input{compressed},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 4, Id: "4444", Source: "ids.gz"}, GeneratorValue{Line: 5, Id: "55555", Source: "ids.gz"}},
+		},
+		{Desc: "mark past end of changed input read and skipped to position",
+			Instance:  &Generator{Resume: &Mark{Input: path, Position: 4, Line: 4, Offset: 100}},
+			Inputs:    []Input{file},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 5, Id: "55555", Source: path}},
+		},
+	}
+	CheckTestCases(t, tests)
+}
+
+func TestGeneratorResumeAfterProgress(t *testing.T) {
+	path := writeInput(t, "1\n22\n333\n4444\n55555\n")
+	inputs := NewFileInputs([]string{path})
+	first := &Generator{Progress: &Progress{}}
+	readAll(t, first, inputs, func(value GeneratorValue) bool { return value.Line <= 3 })
+	done, ok := first.Progress.Done()
+	require.True(t, ok, "first records done")
+
+	values := readAll(t, &Generator{Resume: &done}, inputs, nil)
+	assert.Equal(t, []GeneratorValue{
+		GeneratorValue{Line: 4, Id: "4444", Source: path},
+		GeneratorValue{Line: 5, Id: "55555", Source: path},
+	}, values, "unfinished records read again")
+}
@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionBzip2 = "bzip2"
+	CompressionXz    = "xz"
+)
+
+var compressionMagic = []struct {
+	Name  string
+	Magic []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// bzip2 stream is "BZh", block size digit 1-9 and magic of first block, or of
+// stream end when stream is empty. Plain id may start with "BZh" too
+var (
+	bzip2Magic      = []byte("BZh")
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// DetectCompression peeks at first bytes of src without consuming them. It
+// peeks byte by byte and stops at first byte unlike magic, so short line on
+// slow stdin is read without waiting for more bytes
+func DetectCompression(src *bufio.Reader) (string, error) {
+	for _, format := range compressionMagic {
+		if ok, err := hasPrefix(src, format.Magic); ok || err != nil {
+			return format.Name, err
+		}
+	}
+	if ok, err := hasPrefix(src, bzip2Magic); !ok || err != nil {
+		return CompressionNone, err
+	}
+	return detectBzip2(src)
+}
+
+// detectBzip2 peeks further only for input starting with "BZh", plain ids
+// may start with it too
+func detectBzip2(src *bufio.Reader) (string, error) {
+	head, err := src.Peek(len(bzip2Magic) + 1)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if len(head) < len(bzip2Magic)+1 || head[3] < '1' || head[3] > '9' {
+		return CompressionNone, nil
+	}
+	for _, magic := range [][]byte{bzip2BlockMagic, bzip2EndMagic} {
+		header := append(append([]byte{}, head...), magic...)
+		if ok, err := hasPrefix(src, header); ok || err != nil {
+			return CompressionBzip2, err
+		}
+	}
+	return CompressionNone, nil
+}
+
+// hasPrefix tells if src starts with prefix, peeking one byte more only while
+// bytes match
+func hasPrefix(src *bufio.Reader, prefix []byte) (bool, error) {
+	for i := range prefix {
+		head, err := src.Peek(i + 1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if head[i] != prefix[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Decompress returns stream of decompressed data if src is gzip, zstd, bzip2
+// or xz, and src itself otherwise. Caller must close returned reader, it does
+// not close src
+func Decompress(src io.Reader) (io.ReadCloser, string, error) {
+	buffered := bufio.NewReader(src)
+	compression, err := DetectCompression(buffered)
+	if err != nil {
+		return nil, "", fmt.Errorf("input read error: %w", err)
+	}
+	var reader io.ReadCloser
+	switch compression {
+	case CompressionGzip:
+		reader, err = gzip.NewReader(buffered)
+	case CompressionZstd:
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			reader = decoder.IOReadCloser()
+		}
+	case CompressionBzip2:
+		reader = ioutil.NopCloser(bzip2.NewReader(buffered))
+	case CompressionXz:
+		var xzReader *xz.Reader
+		xzReader, err = xz.NewReader(buffered)
+		reader = ioutil.NopCloser(xzReader)
+	default:
+		reader = ioutil.NopCloser(buffered)
+	}
+	if err != nil {
+		return nil, compression, fmt.Errorf("%s input: %w", compression, err)
+	}
+	return reader, compression, nil
+}
@@ -0,0 +1,118 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+const compressedIds = "111\n222\n333\n"
+
+// printf '111\n222\n333\n' | bzip2
+var bzip2Ids = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x0f, 0x8b, 0x49, 0x1f, 0x00, 0x00,
+	0x02, 0xc8, 0x00, 0x00, 0x10, 0x38, 0x00, 0x20, 0x00, 0x21, 0x29, 0xe9, 0xa8, 0x33, 0x4d, 0x2d,
+	0x25, 0x86, 0x1f, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x0f, 0x8b, 0x49, 0x1f,
+}
+
+func compressWith(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error), data string) string {
+	var buf bytes.Buffer
+	writer, err := newWriter(&buf)
+	require.NoError(t, err, "compressor init")
+	_, err = io.WriteString(writer, data)
+	require.NoError(t, err, "compress")
+	require.NoError(t, writer.Close(), "compressor close")
+	return buf.String()
+}
+
+func compressedInputs(t *testing.T) map[string]string {
+	return map[string]string{
+		CompressionNone:  compressedIds,
+		CompressionGzip:  compressWith(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }, compressedIds),
+		CompressionZstd:  compressWith(t, func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }, compressedIds),
+		CompressionXz:    compressWith(t, func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }, compressedIds),
+		CompressionBzip2: string(bzip2Ids),
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	for compression, input := range compressedInputs(t) {
+		reader, detected, err := Decompress(strings.NewReader(input))
+		require.NoError(t, err, "%s: open", compression)
+		assert.Equal(t, compression, detected, "detected compression")
+		data, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err, "%s: read", compression)
+		assert.Equal(t, compressedIds, string(data), "%s: decompressed data", compression)
+		assert.NoError(t, reader.Close(), "%s: close", compression)
+	}
+}
+
+func TestDecompressShortAndBroken(t *testing.T) {
+	for _, input := range []string{"", "1", "\x1f"} {
+		reader, detected, err := Decompress(strings.NewReader(input))
+		require.NoError(t, err, "input %q", input)
+		assert.Equal(t, CompressionNone, detected, "input %q shorter than magic", input)
+		data, _ := ioutil.ReadAll(reader)
+		assert.Equal(t, input, string(data), "input %q passed as is", input)
+	}
+	for _, input := range []string{"BZh\n", "BZh9\n", "BZh91AY&SX\n", "BZhZ1AY&SY\n", "BZhukov-42\nBZh-2\n"} {
+		reader, detected, err := Decompress(strings.NewReader(input))
+		require.NoError(t, err, "input %q", input)
+		assert.Equal(t, CompressionNone, detected, "input %q is plain ids starting with bzip2 magic", input)
+		data, _ := ioutil.ReadAll(reader)
+		assert.Equal(t, input, string(data), "input %q passed as is", input)
+	}
+	// printf '' | bzip2
+	reader, detected, err := Decompress(strings.NewReader("BZh9\x17\x72\x45\x38\x50\x90\x00\x00\x00\x00"))
+	require.NoError(t, err, "empty bzip2 stream")
+	assert.Equal(t, CompressionBzip2, detected, "empty bzip2 stream detected by end of stream magic")
+	data, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err, "empty bzip2 stream read")
+	assert.Empty(t, data, "empty bzip2 stream has no data")
+
+	_, detected, err = Decompress(strings.NewReader("\x1f\x8bbroken"))
+	assert.Error(t, err, "broken gzip header")
+	assert.Equal(t, CompressionGzip, detected, "compression reported for broken input")
+}
+
+func TestDetectCompressionSlowInput(t *testing.T) {
+	for _, line := range []string{"1\n", "\x1f\n", "\x28\xb5\n", "BZ\n", "BZh9\n", "BZh91AY\n"} {
+		src, stdin := io.Pipe()
+		go stdin.Write([]byte(line)) // rest of input not typed yet
+		detected := make(chan string, 1)
+		go func() {
+			compression, err := DetectCompression(bufio.NewReader(src))
+			assert.NoError(t, err, "input %q", line)
+			detected <- compression
+		}()
+		select {
+		case compression := <-detected:
+			assert.Equal(t, CompressionNone, compression, "input %q", line)
+		case <-time.After(time.Second):
+			t.Errorf("detection of %q waits for more input", line)
+		}
+		stdin.Close()
+	}
+}
+
+func TestTableCompressedInput(t *testing.T) {
+	tests := []TestCase{}
+	for compression, input := range compressedInputs(t) {
+		tests = append(tests, TestCase{Desc: compression + " offset and line numbers refer to decompressed lines",
+			Instance:  &Generator{Offset: 1},
+			Input:     input,
+			WantValue: []GeneratorValue{GeneratorValue{Line: 2, Id: "222"}, GeneratorValue{Line: 3, Id: "333"}},
+		})
+	}
+	CheckTestCases(t, tests)
+}
@@ -0,0 +1,146 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math"
+)
+
+const (
+	DefaultDedupExactLimit    = 1000000
+	DefaultDedupCapacity      = 10000000 // Bloom filter of 36MB at DefaultDedupFalsePositive
+	DefaultDedupFalsePositive = 1e-6
+)
+
+// ErrDuplicate is sent to ErrorChannel for id generator already emitted,
+// generator goes on after it
+var ErrDuplicate = errors.New("duplicate file id")
+
+// ErrProbableDuplicate is sent for id found only by Bloom filter: it is
+// dropped like duplicate but may be unique id taken for seen one
+var ErrProbableDuplicate = errors.New("probable duplicate file id")
+
+// DedupSet remembers first ExactLimit ids exactly, with position of their
+// first occurrence. Ids past the limit go to Bloom filter of fixed size:
+// memory does not grow, but filter may take unique id for seen one, with
+// chance FalsePositive while it holds no more than Capacity ids, and growing
+// after that
+type DedupSet struct {
+	ExactLimit    int     // 0 means DefaultDedupExactLimit, negative means Bloom filter only
+	Capacity      uint64  // ids past ExactLimit, 0 means DefaultDedupCapacity
+	FalsePositive float64 // 0 means DefaultDedupFalsePositive
+
+	exact map[string]string // id to position of its first occurrence
+	bloom *bloomFilter
+}
+
+func (set *DedupSet) exactLimit() int {
+	if set.ExactLimit == 0 {
+		return DefaultDedupExactLimit
+	}
+	return set.ExactLimit
+}
+
+// Add remembers id read at position. Id read before gives ErrDuplicate with
+// position of first occurrence, or ErrProbableDuplicate when only Bloom filter
+// knows it
+func (set *DedupSet) Add(id, position string) error {
+	if set.exact == nil {
+		set.exact = make(map[string]string)
+	}
+	if first, ok := set.exact[id]; ok {
+		return fmt.Errorf("%w %s, first at %s", ErrDuplicate, id, first)
+	}
+	if len(set.exact) < set.exactLimit() {
+		set.exact[id] = position
+		return nil
+	}
+	if set.bloom == nil {
+		capacity, falsePositive := set.Capacity, set.FalsePositive
+		if capacity == 0 {
+			capacity = DefaultDedupCapacity
+		}
+		if falsePositive <= 0 || falsePositive >= 1 {
+			falsePositive = DefaultDedupFalsePositive
+		}
+		set.bloom = newBloomFilter(capacity, falsePositive)
+	}
+	if set.bloom.add(id) {
+		return fmt.Errorf("%w %s, first occurrence unknown past exact limit", ErrProbableDuplicate, id)
+	}
+	return nil
+}
+
+// Exact is false once ids go to Bloom filter
+func (set *DedupSet) Exact() bool {
+	return set.bloom == nil
+}
+
+// Len is number of distinct ids, those in Bloom filter are counted as filter
+// sees them
+func (set *DedupSet) Len() int {
+	n := len(set.exact)
+	if set.bloom != nil {
+		n += int(set.bloom.count)
+	}
+	return n
+}
+
+// bloomFilter uses k bit positions from two hashes of id, Kirsch-Mitzenmacher
+type bloomFilter struct {
+	bits         []uint64
+	size         uint64 // in bits
+	hashes       int
+	count        uint64
+	seed1, seed2 maphash.Seed
+}
+
+func newBloomFilter(capacity uint64, falsePositive float64) *bloomFilter {
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	size = (size + 63) / 64 * 64
+	hashes := int(math.Round(float64(size) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &bloomFilter{
+		bits:   make([]uint64, size/64),
+		size:   size,
+		hashes: hashes,
+		seed1:  maphash.MakeSeed(),
+		seed2:  maphash.MakeSeed(),
+	}
+}
+
+func (filter *bloomFilter) bit(h1, h2 uint64, i int) (uint64, uint64) {
+	bit := (h1 + uint64(i)*h2) % filter.size
+	return bit / 64, uint64(1) << (bit % 64)
+}
+
+func (filter *bloomFilter) hash(id string) (uint64, uint64) {
+	return maphash.String(filter.seed1, id), maphash.String(filter.seed2, id) | 1
+}
+
+func (filter *bloomFilter) has(id string) bool {
+	h1, h2 := filter.hash(id)
+	for i := 0; i < filter.hashes; i++ {
+		if word, mask := filter.bit(h1, h2, i); filter.bits[word]&mask == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets bits of id and tells whether all of them were set before
+func (filter *bloomFilter) add(id string) bool {
+	if filter.has(id) {
+		return true
+	}
+	h1, h2 := filter.hash(id)
+	for i := 0; i < filter.hashes; i++ {
+		word, mask := filter.bit(h1, h2, i)
+		filter.bits[word] |= mask
+	}
+	filter.count++
+	return false
+}
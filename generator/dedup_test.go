@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupSetExact(t *testing.T) {
+	set := &DedupSet{ExactLimit: 10}
+	assert.NoError(t, set.Add("a", "in.txt:1"), "first a")
+	assert.NoError(t, set.Add("b", "in.txt:2"), "first b")
+	err := set.Add("a", "in.txt:3")
+	assert.ErrorIs(t, err, ErrDuplicate, "second a")
+	assert.EqualError(t, err, "duplicate file id a, first at in.txt:1", "first occurrence reported")
+	assert.True(t, set.Exact(), "below limit")
+	assert.Equal(t, 2, set.Len(), "two ids")
+}
+
+func TestDedupSetBloomPastExactLimit(t *testing.T) {
+	set := &DedupSet{ExactLimit: 3, Capacity: 1000}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, set.Add(fmt.Sprintf("id-%d", i), fmt.Sprint(i+1)), "first id-%d", i)
+	}
+	assert.True(t, set.Exact(), "at limit")
+	assert.NoError(t, set.Add("id-3", "4"), "first id over limit")
+	assert.False(t, set.Exact(), "over limit")
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, set.Add(fmt.Sprintf("id-%d", i), "5"), ErrDuplicate, "id-%d kept exactly after switch", i)
+	}
+	err := set.Add("id-3", "6")
+	assert.ErrorIs(t, err, ErrProbableDuplicate, "id over limit known by Bloom filter only")
+	assert.NotErrorIs(t, err, ErrDuplicate, "probable duplicate is not certain")
+	assert.Equal(t, 4, set.Len(), "four ids")
+
+	bloomOnly := &DedupSet{ExactLimit: -1}
+	assert.NoError(t, bloomOnly.Add("a", "1"), "first a")
+	assert.ErrorIs(t, bloomOnly.Add("a", "2"), ErrProbableDuplicate, "second a")
+	assert.False(t, bloomOnly.Exact(), "Bloom filter only")
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	filter := newBloomFilter(10000, 0.01)
+	assert.Equal(t, uint64(95872), filter.size, "bits for 1% at 10000 ids")
+	assert.Equal(t, 7, filter.hashes, "hashes for 1%")
+	for i := 0; i < 10000; i++ {
+		filter.add(fmt.Sprintf("seen-%d", i))
+	}
+	count := filter.count
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.has(fmt.Sprintf("unique-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 200, "false positive rate near 1%% at capacity, got %d of 10000", falsePositives)
+	assert.Equal(t, count, filter.count, "has does not add")
+}
+
+func TestTableDedup(t *testing.T) {
+	tests := []TestCase{
+		{Desc: "duplicates dropped and reported",
+			Instance: &Generator{Dedup: &DedupSet{}},
+			Input:    "1\n2\n1\n3\n2\n",
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1"},
+				GeneratorValue{Line: 2, Id: "2"},
+				GeneratorValue{Line: 4, Id: "3"},
+			},
+			WantError: []GeneratorError{
+				GeneratorError{Line: 3, Err: fmt.Errorf("%w %s, first at %s", ErrDuplicate, "1", "1")},
+				GeneratorError{Line: 5, Err: fmt.Errorf("%w %s, first at %s", ErrDuplicate, "2", "2")},
+			},
+		},
+		{Desc: "duplicates across inputs",
+			Instance: &Generator{Dedup: &DedupSet{}},
+			Inputs:   []Input{StringInput("a.txt", "1\n2\n"), StringInput("b.txt", "2\n3\n")},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1", Source: "a.txt"},
+				GeneratorValue{Line: 2, Id: "2", Source: "a.txt"},
+				GeneratorValue{Line: 2, Id: "3", Source: "b.txt"},
+			},
+			WantError: []GeneratorError{
+				GeneratorError{Line: 1, Err: fmt.Errorf("%w %s, first at %s", ErrDuplicate, "2", "a.txt:2"), Source: "b.txt"},
+			},
+		},
+		{Desc: "duplicates kept without dedup",
+			Input: "1\n1\n",
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1"},
+				GeneratorValue{Line: 2, Id: "1"},
+			},
+		},
+	}
+	CheckTestCases(t, tests)
+}
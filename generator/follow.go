@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const DefaultFollowPoll = time.Second
+
+// FollowReader reads file like tail -F: at end of file it waits for more data.
+// File replaced at Path (rotation) is reopened after old one is read to the
+// end, file truncated below read offset is read again from start. Line left
+// unfinished by old file is ended with line break, so it is not glued to first
+// line of new data. Read returns io.EOF when no data came for IdleTimeout, ctx
+// error when ctx is done
+type FollowReader struct {
+	Path        string
+	Poll        time.Duration // DefaultFollowPoll when 0
+	IdleTimeout time.Duration // 0 follows until ctx is done
+
+	ctx      context.Context
+	file     *os.File
+	offset   int64
+	lastData time.Time
+	lineOpen bool // last byte read is not line break
+	endLine  bool // line break goes before data of new file
+}
+
+func NewFollowReader(ctx context.Context, path string, poll, idle time.Duration) *FollowReader {
+	if poll <= 0 {
+		poll = DefaultFollowPoll
+	}
+	return &FollowReader{Path: path, Poll: poll, IdleTimeout: idle, ctx: ctx, lastData: time.Now()}
+}
+
+// reopen switches to file now at Path, returns false if there is none yet
+func (r *FollowReader) reopen() (bool, error) {
+	file, err := os.Open(r.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if r.file != nil {
+		r.file.Close()
+	}
+	r.file, r.offset = file, 0
+	r.endLine = r.lineOpen
+	return true, nil
+}
+
+// changed checks file at Path after end of current file reached, true means
+// there is something new to read
+func (r *FollowReader) changed() (bool, error) {
+	if r.file == nil {
+		return r.reopen()
+	}
+	current, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	latest, err := os.Stat(r.Path)
+	if os.IsNotExist(err) {
+		// rotated away, new file not created yet
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(current, latest) {
+		ok, err := r.reopen()
+		if ok {
+			log.Printf("input %s rotated, reading new file", r.Path)
+		}
+		return ok, err
+	}
+	if latest.Size() < r.offset {
+		log.Printf("input %s truncated, reading from start", r.Path)
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		r.offset = 0
+		r.endLine = r.lineOpen
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *FollowReader) Read(p []byte) (int, error) {
+	for {
+		if r.endLine && len(p) > 0 {
+			log.Printf("input %s: line unfinished by previous file ended", r.Path)
+			r.endLine, r.lineOpen = false, false
+			p[0] = '\n'
+			return 1, nil
+		}
+		if r.file != nil {
+			n, err := r.file.Read(p)
+			if n > 0 {
+				r.lineOpen = p[n-1] != '\n'
+				r.offset += int64(n)
+				r.lastData = time.Now()
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+		}
+		changed, err := r.changed()
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			continue
+		}
+		if r.IdleTimeout > 0 && time.Since(r.lastData) >= r.IdleTimeout {
+			log.Printf("input %s: no new data for %s, stop following", r.Path, r.IdleTimeout)
+			return 0, io.EOF
+		}
+		timer := time.NewTimer(r.Poll)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return 0, r.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *FollowReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err, "open for append")
+	_, err = file.WriteString(data)
+	require.NoError(t, err, "append")
+	require.NoError(t, file.Close(), "close")
+}
+
+func readSome(t *testing.T, reader io.Reader, want string) {
+	buf := make([]byte, len(want))
+	_, err := io.ReadFull(reader, buf)
+	if assert.NoError(t, err, "read %q", want) {
+		assert.Equal(t, want, string(buf), "data read")
+	}
+}
+
+func TestFollowReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.txt")
+	reader := NewFollowReader(context.Background(), path, 5*time.Millisecond, 200*time.Millisecond)
+	defer reader.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		appendFile(t, path, "1\n")
+	}()
+	readSome(t, reader, "1\n")
+
+	appendFile(t, path, "2\n")
+	readSome(t, reader, "2\n")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("3\n"), 0644), "truncate")
+	readSome(t, reader, "3\n")
+
+	appendFile(t, path, "4\n")
+	require.NoError(t, os.Rename(path, path+".1"), "rotate")
+	appendFile(t, path+".1", "5\n")
+	appendFile(t, path, "6\n")
+	readSome(t, reader, "4\n5\n")
+	readSome(t, reader, "6\n")
+
+	started := time.Now()
+	_, err := reader.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err, "idle timeout ends input")
+	assert.GreaterOrEqual(t, int64(time.Since(started)), int64(100*time.Millisecond), "waited for new data")
+}
+
+func TestFollowReaderEndsLineOnRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.txt")
+	appendFile(t, path, "1\n2")
+	reader := NewFollowReader(context.Background(), path, 5*time.Millisecond, 0)
+	defer reader.Close()
+	readSome(t, reader, "1\n2")
+
+	require.NoError(t, os.Rename(path, path+".1"), "rotate")
+	appendFile(t, path, "3\n")
+	readSome(t, reader, "\n3\n")
+
+	appendFile(t, path, "4")
+	readSome(t, reader, "4")
+	require.NoError(t, ioutil.WriteFile(path, []byte("5\n"), 0644), "truncate")
+	readSome(t, reader, "\n5\n")
+}
+
+func TestFollowReaderCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.txt")
+	appendFile(t, path, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := NewFollowReader(ctx, path, 5*time.Millisecond, 0)
+	defer reader.Close()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := reader.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, context.Canceled, "follow stopped")
+}
+
+func TestGeneratorFollow(t *testing.T) {
+	dir := t.TempDir()
+	first, last := filepath.Join(dir, "first.txt"), filepath.Join(dir, "last.txt")
+	appendFile(t, first, "1\n")
+	appendFile(t, last, "2\n")
+
+	gen := &Generator{Follow: true, FollowPoll: 5 * time.Millisecond, FollowIdle: 300 * time.Millisecond}
+	inputs := NewFileInputs([]string{first, last})
+	gen.InitInputs(inputs)
+	gen.Go(context.Background())
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		appendFile(t, last, "3\n4")
+		time.Sleep(50 * time.Millisecond)
+		appendFile(t, last, "\n")
+	}()
+	ids := make([]string, 0)
+	for value := range gen.ValueChannel {
+		ids = append(ids, value.Id)
+	}
+	gen.WG.Wait()
+	assert.Equal(t, []string{"1", "2", "3", "4"}, ids, "first input read, last one followed until idle")
+}
+
+func TestGeneratorFollowReadTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.txt")
+	appendFile(t, path, "1\n")
+	gen := &Generator{Follow: true, FollowPoll: 5 * time.Millisecond, ReadTimeout: 50 * time.Millisecond}
+	gen.InitInputs(NewFileInputs([]string{path}))
+	gen.Go(context.Background())
+	var errs []GeneratorError
+	done := make(chan struct{})
+	go func() {
+		for msg := range gen.ErrorChannel {
+			errs = append(errs, msg)
+		}
+		close(done)
+	}()
+	ids := make([]string, 0)
+	for value := range gen.ValueChannel {
+		ids = append(ids, value.Id)
+	}
+	<-done
+	gen.WG.Wait()
+	assert.Equal(t, []string{"1"}, ids, "data before stall read")
+	if assert.Len(t, errs, 1, "stalled follow is an error") {
+		assert.ErrorContains(t, errs[0].Err, ErrReadTimeout.Error(), "read timeout")
+	}
+}
@@ -3,32 +3,64 @@ package generator
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
-	"regexp"
+	"os"
 	"sync"
+	"time"
 )
 
 type GeneratorError struct {
-	Line uint64
-	Err  error
+	Line    uint64
+	Err     error
+	Source  string // input name, empty for single unnamed input
+	Skipped bool   // bad line dropped by InvalidPolicy, generator goes on
 }
 
 type GeneratorValue struct {
-	Line uint64
-	Id   string
+	Line   uint64
+	Id     string
+	Source string
+	Seq    uint64 // position to pass to Progress.Finish, 0 without Progress
+}
+
+func (value GeneratorValue) Position() string {
+	return Position(value.Source, value.Line)
+}
+
+func (value GeneratorError) Position() string {
+	return Position(value.Source, value.Line)
 }
 
 type Generator struct {
 	ValueChannel         chan GeneratorValue
 	ErrorChannel         chan GeneratorError
 	DoneChannel          chan struct{}
-	id_src               io.Reader
-	Offset               uint64
+	inputs               []Input // may be compressed, see Decompress
+	Offset               uint64  // records to skip, header line is not a record
 	Limit                uint64
 	ValueChannelCapacity uint64
 	ErrorChannelCapacity uint64
+	Format               string        // lines (default), csv, tsv or jsonl
+	Column               string        // csv/tsv column: 1-based number or name from header
+	Header               bool          // csv/tsv input starts with header line
+	FieldPath            string        // jsonl: dot separated path to file id
+	Dedup                *DedupSet     // drop ids already emitted, nil to keep duplicates
+	Validators           []Validator   // nil means DefaultValidators
+	Shard                *Shard        // nil reads all ids
+	InvalidPolicy        string        // abort (default), skip or quarantine
+	Quarantine           io.Writer     // bad lines go here when InvalidPolicy is quarantine
+	IndexEvery           uint64        // seek to Offset by sidecar line index of this step, 0 disables
+	ReadTimeout          time.Duration // input stalled this long is an error, 0 waits forever
+	Follow               bool          // keep reading data appended to last input file, like tail -F
+	FollowPoll           time.Duration // how often followed file is checked, DefaultFollowPoll when 0
+	FollowIdle           time.Duration // stop following after no new data for this long, 0 never stops
+	Progress             *Progress     // follows emitted ids till caller finishes them, nil disables
+	Resume               *Mark         // start after this record of previous run, Offset is ignored
+	resumeAt             *Mark         // input to open at byte offset of Resume
 	WG                   sync.WaitGroup
 }
 
@@ -42,18 +74,22 @@ type Generator struct {
 //}
 
 func (gen *Generator) Init(id_src io.Reader) {
+	gen.InitInputs([]Input{{Open: func() (io.ReadCloser, error) { return ioutil.NopCloser(id_src), nil }}})
+}
+
+// InitInputs makes generator read inputs one after another. Offset and Limit
+// count records of all inputs together, line numbers start over in every input
+func (gen *Generator) InitInputs(inputs []Input) {
 	gen.ValueChannel = make(chan GeneratorValue, gen.ValueChannelCapacity)
 	gen.ErrorChannel = make(chan GeneratorError, gen.ErrorChannelCapacity)
 	gen.DoneChannel = make(chan struct{}, 1)
 	//gen.WG = sync.WaitGroup{}
-	gen.id_src = id_src
+	gen.inputs = inputs
 }
 
 func (gen *Generator) Go(ctx context.Context) {
-	invalidFileIdRE := regexp.MustCompile(`\s`)
 	gen.WG.Add(1)
 	go func() {
-		scaner := bufio.NewScanner(gen.id_src)
 		defer func() {
 			close(gen.ValueChannel)
 			close(gen.ErrorChannel)
@@ -62,34 +98,316 @@ func (gen *Generator) Go(ctx context.Context) {
 			gen.WG.Done()
 		}()
 		var position uint64
-		for scaner.Scan() { // FIXME: тут есть шанс надолго заблокироваться
-			select {
-			case <-ctx.Done():
-				log.Printf("generator interrupted after line %d", position)
+		inputs := gen.inputs
+		if gen.Resume != nil {
+			inputs = gen.resume(&position)
+		}
+		for i, input := range inputs {
+			input.follow = gen.Follow && i == len(inputs)-1
+			if !gen.readInput(ctx, input, &position) {
 				return
-			default:
 			}
-			position++
-			if position <= gen.Offset {
-				continue
-			}
-			if gen.Limit > 0 && position > gen.Offset+gen.Limit {
-				break
+		}
+	}()
+}
+
+// seekable inputs are plain local files of line based formats, where byte
+// offset of record can be seeked to
+func (gen *Generator) seekable(input Input) bool {
+	if input.Path == "" || input.NewReader != nil || input.Source != nil || input.follow {
+		return false
+	}
+	return gen.Format == "" || gen.Format == FormatLines || gen.Format == FormatJSONL
+}
+
+// resume returns inputs left to read after Resume. Input of Resume is seeked
+// to byte offset and inputs before it are not opened. When that is not
+// possible records up to Resume are skipped by Offset
+func (gen *Generator) resume(position *uint64) []Input {
+	mark := *gen.Resume
+	gen.Offset = mark.Position
+	for i, input := range gen.inputs {
+		if input.Name != mark.Input {
+			continue
+		}
+		input.follow = gen.Follow && i == len(gen.inputs)-1
+		if mark.Offset < 0 || !gen.seekable(input) {
+			break
+		}
+		if err := checkResumeOffset(input.Path, mark.Offset); err != nil {
+			log.Printf("input %s can not resume at byte %d: %s", input.Name, mark.Offset, err)
+			break
+		}
+		log.Printf("resume after %s at byte %d", Position(input.Name, mark.Line), mark.Offset)
+		*position = mark.Position
+		gen.resumeAt = &mark
+		return gen.inputs[i:]
+	}
+	log.Printf("resume after record %d of %s, records before it are read and skipped", mark.Position, mark.Input)
+	return gen.inputs
+}
+
+// checkResumeOffset rejects input that is compressed or shorter than offset
+func checkResumeOffset(path string, offset int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < offset {
+		return fmt.Errorf("input is %d bytes long", stat.Size())
+	}
+	if compression, err := DetectCompression(bufio.NewReader(file)); err != nil {
+		return err
+	} else if compression != CompressionNone {
+		return fmt.Errorf("input is %s compressed", compression)
+	}
+	return nil
+}
+
+// seekInput uses line index to skip Offset without reading lines. Only plain
+// inputs of line based formats can seek, for others ok is false
+func (gen *Generator) seekInput(ctx context.Context, input Input, position *uint64) (RecordReader, func(), bool, error) {
+	if gen.IndexEvery == 0 || input.Path == "" || input.NewReader != nil || input.Source != nil || *position >= gen.Offset {
+		return nil, nil, false, nil
+	}
+	if gen.Format != "" && gen.Format != FormatLines && gen.Format != FormatJSONL {
+		return nil, nil, false, nil
+	}
+	index, err := LoadOrBuildLineIndex(input.Path, gen.IndexEvery)
+	if err != nil {
+		log.Printf("input %s read without line index: %s", input.Name, err)
+		return nil, nil, false, nil
+	}
+	skip := gen.Offset - *position
+	if skip >= index.Lines {
+		*position += index.Lines
+		return nil, nil, true, errInputSkipped
+	}
+	offset, lines := index.Seek(skip)
+	if lines > 0 {
+		log.Printf("input %s: seek to line %d at byte %d by line index", input.Name, lines+1, offset)
+	}
+	reader, closer, err := gen.openAt(ctx, input, offset, lines)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	*position += lines
+	return reader, closer, true, nil
+}
+
+// openAt opens seekable input at byte offset where line after lines starts
+func (gen *Generator) openAt(ctx context.Context, input Input, offset int64, lines uint64) (RecordReader, func(), error) {
+	file, err := os.Open(input.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	src := NewCancelReader(ctx, file, gen.ReadTimeout)
+	closer := func() {
+		file.Close()
+		src.Close()
+	}
+	reader, err := NewRecordReader(src, gen.Format, gen.Column, gen.Header, gen.FieldPath)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+	// line numbers and offsets go on from where seek put us
+	switch r := reader.(type) {
+	case *LineReader:
+		r.line, r.offset = lines, offset
+	case *JSONLReader:
+		r.lines.line, r.lines.offset = lines, offset
+	}
+	return reader, closer, nil
+}
+
+// openInput returns reader of input records and function releasing input
+func (gen *Generator) openInput(ctx context.Context, input Input, position *uint64) (RecordReader, func(), error) {
+	if input.follow && input.Path != "" && input.Source == nil {
+		// followed file is plain text, stdin follows on its own
+		follow := NewFollowReader(ctx, input.Path, gen.FollowPoll, gen.FollowIdle)
+		src := NewCancelReader(ctx, follow, gen.ReadTimeout)
+		closer := func() {
+			follow.Close()
+			src.Close()
+		}
+		reader, err := gen.newReader(input, src)
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+		return reader, closer, nil
+	}
+	if gen.resumeAt != nil && gen.resumeAt.Input == input.Name {
+		mark := gen.resumeAt
+		gen.resumeAt = nil
+		return gen.openAt(ctx, input, mark.Offset, mark.Line)
+	}
+	if reader, closer, ok, err := gen.seekInput(ctx, input, position); ok {
+		return reader, closer, err
+	}
+	if input.Source != nil {
+		reader, err := input.Source.Open(ctx)
+		if err != nil {
+			input.Source.Close()
+			return nil, nil, err
+		}
+		return reader, func() { input.Source.Close() }, nil
+	}
+	raw, err := input.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	// closing raw unblocks read left behind by cancelled CancelReader
+	cancellable := NewCancelReader(ctx, raw, gen.ReadTimeout)
+	src, compression, err := Decompress(cancellable)
+	if err != nil {
+		raw.Close()
+		cancellable.Close()
+		return nil, nil, err
+	}
+	closer := func() {
+		src.Close()
+		raw.Close()
+		cancellable.Close()
+	}
+	if compression != CompressionNone {
+		log.Printf("input %s is %s compressed", input.Name, compression)
+	}
+	reader, err := gen.newReader(input, src)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+	return reader, closer, nil
+}
+
+func (gen *Generator) newReader(input Input, src io.Reader) (RecordReader, error) {
+	if input.NewReader != nil {
+		return input.NewReader(src)
+	}
+	return NewRecordReader(src, gen.Format, gen.Column, gen.Header, gen.FieldPath)
+}
+
+// readInput returns false when generator must stop: on error, interruption
+// or when Limit reached
+func (gen *Generator) readInput(ctx context.Context, input Input, position *uint64) bool {
+	validators := gen.Validators
+	if validators == nil {
+		validators = DefaultValidators()
+	}
+	reader, closer, err := gen.openInput(ctx, input, position)
+	if err == errInputSkipped {
+		return true
+	}
+	if err != nil {
+		gen.ErrorChannel <- GeneratorError{Err: err, Source: input.Name}
+		return false
+	}
+	defer closer()
+	offsets, _ := reader.(interface{ Offset() int64 })
+	if !gen.seekable(input) {
+		offsets = nil
+	}
+	mark := func(record Record) Mark {
+		at := Mark{Input: input.Name, Position: *position, Line: record.Line, Offset: -1, Key: record.Key}
+		if offsets != nil {
+			at.Offset = offsets.Offset()
+		}
+		return at
+	}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil && ctx.Err() != nil {
+			log.Printf("generator interrupted while reading input %s: %s", input.Name, err)
+			return false
+		}
+		if err != nil {
+			var recordErr *RecordError
+			if errors.As(err, &recordErr) {
+				// broken record takes its place, so Offset means the same
+				// lines with and without line index
+				*position++
+				if *position <= gen.Offset {
+					continue
+				}
+				if gen.Limit > 0 && *position > gen.Offset+gen.Limit {
+					return false
+				}
+				if gen.invalid(input, recordErr.Line, recordErr.Text, recordErr.Err) {
+					gen.pass(mark(Record{Line: recordErr.Line}))
+					continue
+				}
+				return false
 			}
-			text := scaner.Text()
-			if len(text) == 0 {
+			gen.ErrorChannel <- GeneratorError{Line: record.Line, Err: err, Source: input.Name}
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("generator interrupted after line %s", Position(input.Name, record.Line))
+			return false
+		default:
+		}
+		*position++
+		if *position <= gen.Offset {
+			continue
+		}
+		if gen.Limit > 0 && *position > gen.Offset+gen.Limit {
+			return false
+		}
+		text := record.Id
+		if len(text) == 0 {
+			gen.pass(mark(record))
+			continue
+		}
+		// bad ids are reported by owner shard only, broken records by all
+		if gen.Shard != nil && !gen.Shard.Owns(text, *position) {
+			gen.pass(mark(record))
+			continue
+		}
+		if err := validate(validators, record); err != nil {
+			if gen.invalid(input, record.Line, record.Id, err) {
+				gen.pass(mark(record))
 				continue
 			}
-			if invalidFileIdRE.Match([]byte(text)) {
-				gen.ErrorChannel <- GeneratorError{Line: position, Err: fmt.Errorf("file id may not contain spaces")}
-				return
-			}
+			return false
+		}
 
-			gen.ValueChannel <- GeneratorValue{Line: position, Id: text}
+		// ids skipped by Offset are not remembered, so resumed run may repeat them
+		if gen.Dedup != nil {
+			if err := gen.Dedup.Add(text, Position(input.Name, record.Line)); err != nil {
+				gen.ErrorChannel <- GeneratorError{Line: record.Line, Err: err, Source: input.Name}
+				gen.pass(mark(record))
+				continue
+			}
 		}
-		if err := scaner.Err(); err != nil {
-			gen.ErrorChannel <- GeneratorError{Err: fmt.Errorf("scan error: %s", err)}
-			return
+
+		value := GeneratorValue{Line: record.Line, Id: text, Source: input.Name}
+		if gen.Progress != nil {
+			gen.Progress.Read(mark(record))
+			value.Seq = *position
 		}
-	}()
+		gen.ValueChannel <- value
+	}
+}
+
+// pass records record that gets no task as finished
+func (gen *Generator) pass(mark Mark) {
+	if gen.Progress != nil {
+		gen.Progress.Read(mark)
+		gen.Progress.Finish(mark.Position)
+	}
 }
@@ -21,7 +21,7 @@ func TestRead3Lines(t *testing.T) {
 	gen.Init(inputReader)
 	ctx, _ := context.WithCancel(context.Background())
 	gen.Go(ctx)
-	expect := []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}}
+	expect := []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}}
 	got := make([]GeneratorValue, 0, 3)
 	for line := range gen.ValueChannel {
 		got = append(got, line)
@@ -33,6 +33,7 @@ func TestRead3Lines(t *testing.T) {
 type TestCase struct {
 	Instance          *Generator
 	Input             string
+	Inputs            []Input // used instead of Input when set
 	WantValue         []GeneratorValue
 	WantError         []GeneratorError
 	Desc              string
@@ -46,7 +47,11 @@ func CheckTestCases(t *testing.T, tests []TestCase) {
 		if gen == nil {
 			gen = &Generator{}
 		}
-		gen.Init(inputReader)
+		if test.Inputs != nil {
+			gen.InitInputs(test.Inputs)
+		} else {
+			gen.Init(inputReader)
+		}
 		//log.Printf("generator values cap: %+v", cap(gen.ValueChannel))
 		ctx, ctxCancel := context.WithCancel(context.Background())
 		gen.Go(ctx)
@@ -94,68 +99,106 @@ func TestTableReadLines(t *testing.T) {
 	tests := []TestCase{
 		{Desc: "three single-char lines",
 			Input:     "1\n2\n3",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "lines have spaces",
 			Input:     "11\n2 2\n3",
-			WantValue: []GeneratorValue{GeneratorValue{1, "11"}},
-			WantError: []GeneratorError{GeneratorError{2, fmt.Errorf("file id may not contain spaces")}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "11"}},
+			WantError: []GeneratorError{GeneratorError{Line: 2, Err: fmt.Errorf("file id may not contain spaces")}},
 		},
 		{Desc: "newline at the end",
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "empty line in the middle",
 			Input:     "1\n2\n\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{4, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 4, Id: "3"}},
 		},
 		{Desc: "offset 1",
 			Instance:  &Generator{Offset: 1},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "offset 1 limit 1",
 			Instance:  &Generator{Offset: 1, Limit: 1},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{2, "2"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 2, Id: "2"}},
 		},
 		{Desc: "offset 0 limit 2",
 			Instance:  &Generator{Offset: 0, Limit: 2},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}},
 		},
 		{Desc: "limit 0 ignored",
 			Instance:  &Generator{Offset: 0, Limit: 0},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "offset 1 but limit 0",
 			Instance:  &Generator{Offset: 1, Limit: 0},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "buffered values channel",
 			Instance:  &Generator{ValueChannelCapacity: 5},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "buffered error channel",
 			Instance:  &Generator{ErrorChannelCapacity: 2},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "buffered both error and values channels",
 			Instance:  &Generator{ValueChannelCapacity: 5, ErrorChannelCapacity: 2},
 			Input:     "1\n2\n3\n",
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}, GeneratorValue{3, "3"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}, GeneratorValue{Line: 3, Id: "3"}},
 		},
 		{Desc: "three single-char lines canceled after first line",
 			CancelAfterNLoops: 1,
 			Input:             "1\n2\n3",
 			// FIXME: получаем два результата, первый send в канал не блокирует горутину
-			WantValue: []GeneratorValue{GeneratorValue{1, "1"}, GeneratorValue{2, "2"}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}},
 		},
 	}
 	CheckTestCases(t, tests)
 
 }
+
+func TestTableInputFormats(t *testing.T) {
+	tests := []TestCase{
+		{Desc: "csv with header",
+			Instance:  &Generator{Format: FormatCSV, Column: "id", Header: true},
+			Input:     "id,size\n1,10\n2,20\n",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 2, Id: "1"}, GeneratorValue{Line: 3, Id: "2"}},
+		},
+		{Desc: "csv offset counts records, not header",
+			Instance:  &Generator{Format: FormatCSV, Column: "1", Header: true, Offset: 1},
+			Input:     "id,size\n1,10\n2,20\n",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 3, Id: "2"}},
+		},
+		{Desc: "tsv by column number",
+			Instance:  &Generator{Format: FormatTSV, Column: "2"},
+			Input:     "a\t1\nb\t2\n",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2"}},
+		},
+		{Desc: "jsonl field path",
+			Instance:  &Generator{Format: FormatJSONL, FieldPath: "file.id"},
+			Input:     "{\"file\":{\"id\":\"1\"}}\n\n{\"file\":{\"id\":\"2\"}}\n",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 3, Id: "2"}},
+		},
+		{Desc: "jsonl broken record",
+			Instance:  &Generator{Format: FormatJSONL, FieldPath: "id"},
+			Input:     "{\"id\":\"1\"}\n{\"name\":\"2\"}\n",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}},
+			WantError: []GeneratorError{GeneratorError{Line: 2, Err: fmt.Errorf("field \"id\" not found")}},
+		},
+		{Desc: "unknown format",
+			Instance:  &Generator{Format: "xml"},
+			Input:     "1\n",
+			WantValue: []GeneratorValue{},
+			WantError: []GeneratorError{GeneratorError{Line: 0, Err: fmt.Errorf("unknown input format \"xml\", expect lines, csv, tsv or jsonl")}},
+		},
+	}
+	CheckTestCases(t, tests)
+}
@@ -0,0 +1,197 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// LineIndexSuffix is appended to input path to get its index path
+	LineIndexSuffix   = ".lidx"
+	lineIndexVersion  = 1
+	DefaultIndexEvery = 100000
+)
+
+var (
+	ErrIndexStale    = errors.New("line index does not match input")
+	errInputSkipped  = errors.New("input skipped by offset")
+	errNotIndexable  = errors.New("compressed input can not be indexed")
+	indexReadBufSize = 1 << 20
+)
+
+// LineIndex is byte offset of every Every-th line of plain text input.
+// Offsets[i] is where line i*Every+1 starts. Size and ModTime of input are
+// kept to reject index of changed file
+type LineIndex struct {
+	Version int     `json:"version"`
+	Size    int64   `json:"size"`
+	ModTime int64   `json:"mod_time"` // unix nanoseconds
+	Every   uint64  `json:"every"`
+	Lines   uint64  `json:"lines"`
+	Offsets []int64 `json:"offsets"`
+}
+
+func LineIndexPath(path string) string {
+	return path + LineIndexSuffix
+}
+
+// isLineIndex matches index files and temporary files of Save
+func isLineIndex(path string) bool {
+	return strings.HasSuffix(path, LineIndexSuffix) || strings.Contains(filepath.Base(path), LineIndexSuffix+".")
+}
+
+// BuildLineIndex scans input for line breaks, the way LineReader splits lines
+func BuildLineIndex(path string, every uint64) (*LineIndex, error) {
+	if every == 0 {
+		every = DefaultIndexEvery
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReaderSize(file, indexReadBufSize)
+	if compression, err := DetectCompression(buffered); err != nil {
+		return nil, err
+	} else if compression != CompressionNone {
+		return nil, errNotIndexable
+	}
+
+	index := &LineIndex{Version: lineIndexVersion, Size: stat.Size(), ModTime: stat.ModTime().UnixNano(), Every: every, Offsets: []int64{0}}
+	buf := make([]byte, indexReadBufSize)
+	var offset int64
+	lineOpen := false // bytes after last line break
+	for {
+		n, err := buffered.Read(buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				lineOpen = true
+				offset += int64(len(chunk))
+				break
+			}
+			offset += int64(i + 1)
+			chunk = chunk[i+1:]
+			lineOpen = false
+			index.Lines++
+			if index.Lines%every == 0 {
+				index.Offsets = append(index.Offsets, offset)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if lineOpen {
+		index.Lines++
+	}
+	if offset != index.Size {
+		return nil, fmt.Errorf("input %s changed while indexing", path)
+	}
+	if index.Lines%every == 0 && !lineOpen && len(index.Offsets) > 1 {
+		// offset of line after the last one
+		index.Offsets = index.Offsets[:len(index.Offsets)-1]
+	}
+	return index, nil
+}
+
+// Check rejects index built for other version of input
+func (index *LineIndex) Check(path string, every uint64) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if index.Version != lineIndexVersion || index.Size != stat.Size() || index.ModTime != stat.ModTime().UnixNano() {
+		return ErrIndexStale
+	}
+	if every != 0 && index.Every != every {
+		return fmt.Errorf("line index every %d lines, want %d", index.Every, every)
+	}
+	return nil
+}
+
+func LoadLineIndex(path string) (*LineIndex, error) {
+	data, err := ioutil.ReadFile(LineIndexPath(path))
+	if err != nil {
+		return nil, err
+	}
+	index := &LineIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("line index %s: %w", LineIndexPath(path), err)
+	}
+	return index, nil
+}
+
+// Save writes index next to input through temporary file, so concurrent
+// shards never read half written index
+func (index *LineIndex) Save(path string) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(LineIndexPath(path))+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), LineIndexPath(path))
+}
+
+// LoadOrBuildLineIndex returns stored index when it matches input, otherwise
+// builds new one and stores it. Index that can not be saved is still used
+func LoadOrBuildLineIndex(path string, every uint64) (*LineIndex, error) {
+	if every == 0 {
+		every = DefaultIndexEvery
+	}
+	index, err := LoadLineIndex(path)
+	if err == nil {
+		if err = index.Check(path, every); err == nil {
+			return index, nil
+		}
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("line index of %s rebuilt: %s", path, err)
+	}
+	index, err = BuildLineIndex(path, every)
+	if err != nil {
+		return nil, err
+	}
+	if err := index.Save(path); err != nil {
+		log.Printf("line index of %s not saved: %s", path, err)
+	}
+	return index, nil
+}
+
+// Seek finds closest indexed line not after skip lines, returns its byte
+// offset and number of lines before it
+func (index *LineIndex) Seek(skip uint64) (int64, uint64) {
+	i := skip / index.Every
+	if i >= uint64(len(index.Offsets)) {
+		i = uint64(len(index.Offsets)) - 1
+	}
+	return index.Offsets[i], i * index.Every
+}
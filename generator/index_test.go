@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeInput(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "ids.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644), "input written")
+	return path
+}
+
+func TestBuildLineIndex(t *testing.T) {
+	tests := []struct {
+		Data    string
+		Lines   uint64
+		Offsets []int64
+	}{
+		{Data: "", Lines: 0, Offsets: []int64{0}},
+		{Data: "1\n22\n333\n4444\n55555", Lines: 5, Offsets: []int64{0, 5, 14}},
+		{Data: "1\n22\n333\n4444\n", Lines: 4, Offsets: []int64{0, 5}},
+		{Data: "1\r\n\n3\n", Lines: 3, Offsets: []int64{0, 4}},
+	}
+	for _, test := range tests {
+		index, err := BuildLineIndex(writeInput(t, test.Data), 2)
+		if assert.NoError(t, err, "index of %q", test.Data) {
+			assert.Equal(t, test.Lines, index.Lines, "lines of %q", test.Data)
+			assert.Equal(t, test.Offsets, index.Offsets, "offsets of %q", test.Data)
+		}
+	}
+
+	_, err := BuildLineIndex(writeInput(t, compressedInputs(t)[CompressionGzip]), 2)
+	assert.Error(t, err, "compressed input")
+}
+
+func TestLineIndexRejectedWhenInputChanged(t *testing.T) {
+	path := writeInput(t, "1\n2\n3\n")
+	index, err := LoadOrBuildLineIndex(path, 2)
+	require.NoError(t, err, "index built")
+	_, err = os.Stat(LineIndexPath(path))
+	assert.NoError(t, err, "index stored next to input")
+
+	loaded, err := LoadLineIndex(path)
+	if assert.NoError(t, err, "index loaded") {
+		assert.Equal(t, index, loaded, "same index loaded")
+		assert.NoError(t, loaded.Check(path, 2), "index matches input")
+		assert.Error(t, loaded.Check(path, 3), "index of other step")
+	}
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("1\n2\n3\n4\n"), 0644), "input changed")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later), "mtime changed")
+	assert.ErrorIs(t, loaded.Check(path, 2), ErrIndexStale, "stale index rejected")
+
+	rebuilt, err := LoadOrBuildLineIndex(path, 2)
+	if assert.NoError(t, err, "index rebuilt") {
+		assert.Equal(t, uint64(4), rebuilt.Lines, "lines of changed input")
+	}
+}
+
+func TestTableLineIndexOffset(t *testing.T) {
+	var data strings.Builder
+	for i := 1; i <= 10; i++ {
+		data.WriteString(strings.Repeat("x", i) + "\n")
+	}
+	first := writeInput(t, "a\n\"b\nc\n")
+	second := writeInput(t, data.String())
+	inputs := NewFileInputs([]string{first, second})
+	for i := range inputs {
+		inputs[i].Name = filepath.Base(filepath.Dir(inputs[i].Path))
+	}
+	name := inputs[1].Name
+	want := []GeneratorValue{
+		GeneratorValue{Line: 6, Id: "xxxxxx", Source: name},
+		GeneratorValue{Line: 7, Id: "xxxxxxx", Source: name},
+	}
+	tests := []TestCase{
+		{Desc: "offset without index",
+			Instance:  &Generator{Offset: 8, Limit: 2},
+			Inputs:    inputs,
+			WantValue: want,
+		},
+		{Desc: "offset with index skips first input and seeks in second",
+			Instance:  &Generator{Offset: 8, Limit: 2, IndexEvery: 4},
+			Inputs:    inputs,
+			WantValue: want,
+		},
+		{Desc: "index ignored when input has own reader",
+			Instance:  &Generator{Offset: 1, Limit: 1, IndexEvery: 4},
+			Inputs:    []Input{{Name: "own", Path: second, Open: inputs[1].Open, NewReader: func(src io.Reader) (RecordReader, error) { return NewLineReader(src), nil }}},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 2, Id: "xx", Source: "own"}},
+		},
+	}
+	CheckTestCases(t, tests)
+	_, err := os.Stat(LineIndexPath(second))
+	assert.NoError(t, err, "index stored")
+}
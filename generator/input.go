@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StdinPath in input list means standard input
+const StdinPath = "-"
+
+// Input is one source of file ids. Inputs are opened one by one when generator
+// gets to them, so long input lists do not hold open files
+type Input struct {
+	Name string
+	Path string // local file, empty for stdin and remote inputs; enables line index
+	Open func() (io.ReadCloser, error)
+	// NewReader overrides generator Format for inputs with their own layout,
+	// like S3 Inventory data files
+	NewReader func(src io.Reader) (RecordReader, error)
+	Source    Source // input of records, not bytes; Open and NewReader are not used
+	follow    bool   // last input in follow mode
+}
+
+// Position is "file:line" for named inputs and just line number otherwise
+func Position(source string, line uint64) string {
+	if source == "" {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%s:%d", source, line)
+}
+
+// ExpandInputPaths replaces directories with files they contain and glob
+// patterns with files they match, both in lexical order. Hidden files and
+// subdirectories are skipped, so are line indexes. Path that does not exist or pattern that matches
+// nothing is an error, not an empty input
+func ExpandInputPaths(paths []string) ([]string, error) {
+	expanded := make([]string, 0, len(paths))
+	stdin := false
+	for _, path := range paths {
+		if path == StdinPath {
+			if stdin {
+				return nil, fmt.Errorf("stdin input %q given more than once", StdinPath)
+			}
+			stdin = true
+			expanded = append(expanded, path)
+			continue
+		}
+		if strings.ContainsAny(path, "*?[") {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("input pattern %s: %w", path, err)
+			}
+			files := make([]string, 0, len(matches))
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() && !isLineIndex(match) {
+					files = append(files, match)
+				}
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("input pattern %s matches no files", path)
+			}
+			sort.Strings(files)
+			expanded = append(expanded, files...)
+			continue
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("input %s does not exist", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("input directory %s: %w", path, err)
+		}
+		found := 0
+		for _, entry := range entries { // ReadDir sorts by name
+			if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") || isLineIndex(entry.Name()) {
+				continue
+			}
+			expanded = append(expanded, filepath.Join(path, entry.Name()))
+			found++
+		}
+		if found == 0 {
+			return nil, fmt.Errorf("input directory %s has no files", path)
+		}
+	}
+	return expanded, nil
+}
+
+// OpenInput opens file or stdin for StdinPath. Stdin is not closed by
+// returned ReadCloser
+func OpenInput(path string) (io.ReadCloser, error) {
+	if path == StdinPath {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("input %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// NewFileInputs makes inputs for paths returned by ExpandInputPaths
+func NewFileInputs(paths []string) []Input {
+	inputs := make([]Input, 0, len(paths))
+	for _, path := range paths {
+		path := path
+		input := Input{Name: path, Path: path, Open: func() (io.ReadCloser, error) { return OpenInput(path) }}
+		if path == StdinPath {
+			input.Name, input.Path = "stdin", ""
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs
+}
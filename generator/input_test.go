@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func StringInput(name, data string) Input {
+	return Input{Name: name, Open: func() (io.ReadCloser, error) { return ioutil.NopCloser(strings.NewReader(data)), nil }}
+}
+
+func WriteInputFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755), "mkdir for %s", name)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644), "write %s", name)
+	}
+}
+
+func TestExpandInputPaths(t *testing.T) {
+	dir := t.TempDir()
+	WriteInputFiles(t, dir, map[string]string{
+		"ids/02.txt":      "2\n",
+		"ids/01.txt":      "1\n",
+		"ids/.hidden":     "x\n",
+		"ids/01.txt.lidx": "{}",
+		"ids/sub/03.txt":  "3\n",
+		"single.txt":      "0\n",
+		"empty/.keep":     "",
+	})
+	join := func(name string) string { return filepath.Join(dir, name) }
+
+	paths, err := ExpandInputPaths([]string{join("single.txt"), join("ids"), StdinPath, join("ids/0*.txt")})
+	assert.NoError(t, err, "expand")
+	assert.Equal(t, []string{
+		join("single.txt"), join("ids/01.txt"), join("ids/02.txt"), StdinPath, join("ids/01.txt"), join("ids/02.txt"),
+	}, paths, "given order kept, directory and pattern sorted, hidden files, indexes and subdirectories skipped")
+
+	for _, bad := range [][]string{
+		{join("missing.txt")},
+		{join("ids/*.csv")},
+		{join("empty")},
+		{StdinPath, StdinPath},
+	} {
+		_, err := ExpandInputPaths(bad)
+		assert.Error(t, err, "expand %q", bad)
+	}
+}
+
+func TestOpenInputMissing(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	_, err := OpenInput(missing)
+	if assert.Error(t, err, "missing input") {
+		assert.Contains(t, err.Error(), missing, "error names input")
+	}
+}
+
+func TestTableMultipleInputs(t *testing.T) {
+	tests := []TestCase{
+		{Desc: "line numbers start over in every input",
+			Inputs: []Input{StringInput("a.txt", "1\n2\n"), StringInput("b.txt", "\n3\n")},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1", Source: "a.txt"},
+				GeneratorValue{Line: 2, Id: "2", Source: "a.txt"},
+				GeneratorValue{Line: 2, Id: "3", Source: "b.txt"},
+			},
+		},
+		{Desc: "offset and limit span inputs",
+			Instance: &Generator{Offset: 1, Limit: 2},
+			Inputs:   []Input{StringInput("a.txt", "1\n2\n"), StringInput("b.txt", "3\n4\n")},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 2, Id: "2", Source: "a.txt"},
+				GeneratorValue{Line: 1, Id: "3", Source: "b.txt"},
+			},
+		},
+		{Desc: "input failing to open stops generator",
+			Inputs: []Input{
+				StringInput("a.txt", "1\n"),
+				{Name: "b.txt", Open: func() (io.ReadCloser, error) { return nil, fmt.Errorf("no such file") }},
+				StringInput("c.txt", "3\n"),
+			},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1", Source: "a.txt"}},
+			WantError: []GeneratorError{GeneratorError{Err: fmt.Errorf("no such file"), Source: "b.txt"}},
+		},
+		{Desc: "input without stream",
+			Inputs: []Input{{Name: "listing", Source: ReaderSource{Reader: NewLineReader(strings.NewReader("1\n2\n"))}}},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1", Source: "listing"},
+				GeneratorValue{Line: 2, Id: "2", Source: "listing"},
+			},
+		},
+		{Desc: "csv header in every input",
+			Instance: &Generator{Format: FormatCSV, Column: "id", Header: true},
+			Inputs:   []Input{StringInput("a.csv", "id\n1\n"), StringInput("b.csv", "name,id\nx,2\n")},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 2, Id: "1", Source: "a.csv"},
+				GeneratorValue{Line: 2, Id: "2", Source: "b.csv"},
+			},
+		},
+	}
+	CheckTestCases(t, tests)
+}
+
+func TestPosition(t *testing.T) {
+	assert.Equal(t, "12", GeneratorValue{Line: 12}.Position(), "unnamed input")
+	assert.Equal(t, "ids.txt:12", GeneratorError{Line: 12, Source: "ids.txt"}.Position(), "named input")
+}
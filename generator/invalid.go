@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	InvalidAbort      = "abort"      // stop reading at first bad line
+	InvalidSkip       = "skip"       // report bad line and go on
+	InvalidQuarantine = "quarantine" // like skip, and write bad line to Quarantine
+)
+
+func ParseInvalidPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return InvalidAbort, nil
+	case InvalidAbort, InvalidSkip, InvalidQuarantine:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown invalid line policy %q, expect %s, %s or %s", policy, InvalidAbort, InvalidSkip, InvalidQuarantine)
+}
+
+// WriteQuarantine writes "position<TAB>error<TAB>text" line, text goes last
+// because it may contain tabs
+func WriteQuarantine(w io.Writer, position string, err error, text string) error {
+	_, writeErr := fmt.Fprintf(w, "%s\t%s\t%s\n", position, err, text)
+	return writeErr
+}
+
+// invalid applies InvalidPolicy to bad line, returns false when generator
+// must stop
+func (gen *Generator) invalid(input Input, line uint64, text string, err error) bool {
+	report := GeneratorError{Line: line, Err: err, Source: input.Name}
+	switch gen.InvalidPolicy {
+	case InvalidSkip:
+	case InvalidQuarantine:
+		if gen.Quarantine == nil {
+			gen.ErrorChannel <- GeneratorError{Line: line, Err: fmt.Errorf("no quarantine to write bad line to: %s", err), Source: input.Name}
+			return false
+		}
+		if writeErr := WriteQuarantine(gen.Quarantine, report.Position(), err, text); writeErr != nil {
+			gen.ErrorChannel <- GeneratorError{Line: line, Err: fmt.Errorf("quarantine write error: %s", writeErr), Source: input.Name}
+			return false
+		}
+	default:
+		gen.ErrorChannel <- report
+		return false
+	}
+	report.Skipped = true
+	gen.ErrorChannel <- report
+	return true
+}
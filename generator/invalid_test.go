@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInvalidPolicy(t *testing.T) {
+	for policy, want := range map[string]string{"": InvalidAbort, "abort": InvalidAbort, "skip": InvalidSkip, "quarantine": InvalidQuarantine} {
+		got, err := ParseInvalidPolicy(policy)
+		assert.NoError(t, err, "policy %q", policy)
+		assert.Equal(t, want, got, "policy %q", policy)
+	}
+	_, err := ParseInvalidPolicy("ignore")
+	assert.Error(t, err, "unknown policy")
+}
+
+func TestLineReaderLongLines(t *testing.T) {
+	input := "1\r\n" + strings.Repeat("x", 10) + "\n2\n" + strings.Repeat("y", 5000) + "\n3"
+	reader := NewLineReader(strings.NewReader(input))
+	reader.MaxLength = 8
+	var ids []string
+	var tooLong []uint64
+	for {
+		record, err := reader.Next()
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			assert.ErrorIs(t, err, ErrLineTooLong, "line %d", recordErr.Line)
+			assert.Len(t, recordErr.Text, 8, "text cut to max length")
+			tooLong = append(tooLong, recordErr.Line)
+			continue
+		}
+		if err != nil {
+			break
+		}
+		ids = append(ids, record.Id)
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, ids, "lines around long ones")
+	assert.Equal(t, []uint64{2, 4}, tooLong, "long lines reported")
+
+	reader = NewLineReader(strings.NewReader(strings.Repeat("z", 8) + "\r\n"))
+	reader.MaxLength = 8
+	record, err := reader.Next()
+	assert.NoError(t, err, "line of max length")
+	assert.Equal(t, strings.Repeat("z", 8), record.Id, "line break is not counted")
+}
+
+func TestTableInvalidPolicy(t *testing.T) {
+	quarantine := &bytes.Buffer{}
+	tests := []TestCase{
+		{Desc: "abort is default",
+			Input:     "1\n\"2\n3",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}},
+			WantError: []GeneratorError{GeneratorError{Line: 2, Err: errors.New("bad quoted id \"2")}},
+		},
+		{Desc: "skip bad lines",
+			Instance:  &Generator{InvalidPolicy: InvalidSkip},
+			Input:     "1\n\"2\n3 3\n4",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 4, Id: "4"}},
+			WantError: []GeneratorError{
+				GeneratorError{Line: 2, Err: errors.New("bad quoted id \"2"), Skipped: true},
+				GeneratorError{Line: 3, Err: errors.New("file id may not contain spaces"), Skipped: true},
+			},
+		},
+		{Desc: "quarantine bad lines",
+			Instance: &Generator{InvalidPolicy: InvalidQuarantine, Quarantine: quarantine},
+			Inputs:   []Input{StringInput("ids.txt", "1\n2 2\n3\n")},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "1", Source: "ids.txt"},
+				GeneratorValue{Line: 3, Id: "3", Source: "ids.txt"},
+			},
+			WantError: []GeneratorError{
+				GeneratorError{Line: 2, Err: errors.New("file id may not contain spaces"), Source: "ids.txt", Skipped: true},
+			},
+		},
+		{Desc: "quarantine without writer aborts",
+			Instance:  &Generator{InvalidPolicy: InvalidQuarantine},
+			Input:     "1 1\n2",
+			WantValue: []GeneratorValue{},
+			WantError: []GeneratorError{GeneratorError{Line: 1, Err: errors.New("no quarantine to write bad line to: file id may not contain spaces")}},
+		},
+		{Desc: "broken csv header is not skipped",
+			Instance:  &Generator{InvalidPolicy: InvalidSkip, Format: FormatCSV, Column: "id", Header: true},
+			Input:     "\"id\nx",
+			WantValue: []GeneratorValue{},
+			WantError: []GeneratorError{GeneratorError{Err: errors.New("csv header: line 1: extraneous or missing \" in quoted-field")}},
+		},
+	}
+	CheckTestCases(t, tests)
+	assert.Equal(t, "ids.txt:2\tfile id may not contain spaces\t2 2\n", quarantine.String(), "quarantined line")
+}
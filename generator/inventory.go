@@ -0,0 +1,217 @@
+package generator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	InventoryFormatCSV     = "CSV"
+	InventoryFormatParquet = "Parquet"
+	InventoryFormatORC     = "ORC"
+)
+
+// InventoryManifest is manifest.json of S3 Inventory report
+type InventoryManifest struct {
+	SourceBucket      string          `json:"sourceBucket"`
+	DestinationBucket string          `json:"destinationBucket"`
+	Version           string          `json:"version"`
+	FileFormat        string          `json:"fileFormat"`
+	FileSchema        string          `json:"fileSchema"`
+	Files             []InventoryFile `json:"files"`
+}
+
+type InventoryFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5checksum string `json:"MD5checksum"`
+}
+
+func ReadInventoryManifest(manifestPath string) (*InventoryManifest, error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("inventory manifest: %w", err)
+	}
+	var manifest InventoryManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("inventory manifest %s: %w", manifestPath, err)
+	}
+	return &manifest, nil
+}
+
+// Columns returns column names from fileSchema, "Bucket, Key, Size, ..."
+func (manifest *InventoryManifest) Columns() []string {
+	columns := strings.Split(manifest.FileSchema, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
+
+// InventoryFilter selects objects from inventory. Zero values do not filter
+type InventoryFilter struct {
+	Prefix         string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+}
+
+type InventoryObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func (filter InventoryFilter) Match(object InventoryObject) bool {
+	switch {
+	case !strings.HasPrefix(object.Key, filter.Prefix):
+		return false
+	case filter.MinSize > 0 && object.Size < filter.MinSize:
+		return false
+	case filter.MaxSize > 0 && object.Size > filter.MaxSize:
+		return false
+	case !filter.ModifiedAfter.IsZero() && !object.LastModified.After(filter.ModifiedAfter):
+		return false
+	case !filter.ModifiedBefore.IsZero() && !object.LastModified.Before(filter.ModifiedBefore):
+		return false
+	}
+	return true
+}
+
+// InventoryReader reads keys from one CSV data file of inventory. Old versions
+// and delete markers of versioned inventory are skipped: only objects which
+// existed when report was made are worth restoring
+type InventoryReader struct {
+	reader       *csv.Reader
+	filter       InventoryFilter
+	key          int
+	size         int
+	lastModified int
+	isLatest     int
+	deleteMarker int
+}
+
+func NewInventoryReader(src io.Reader, columns []string, filter InventoryFilter) (*InventoryReader, error) {
+	index := func(name string) int {
+		for i, column := range columns {
+			if column == name {
+				return i
+			}
+		}
+		return -1
+	}
+	r := &InventoryReader{
+		filter:       filter,
+		key:          index("Key"),
+		size:         index("Size"),
+		lastModified: index("LastModifiedDate"),
+		isLatest:     index("IsLatest"),
+		deleteMarker: index("IsDeleteMarker"),
+	}
+	if r.key < 0 {
+		return nil, fmt.Errorf("inventory schema %q has no Key column", strings.Join(columns, ", "))
+	}
+	if r.size < 0 && (filter.MinSize > 0 || filter.MaxSize > 0) {
+		return nil, fmt.Errorf("inventory has no Size column to filter by")
+	}
+	if r.lastModified < 0 && (!filter.ModifiedAfter.IsZero() || !filter.ModifiedBefore.IsZero()) {
+		return nil, fmt.Errorf("inventory has no LastModifiedDate column to filter by")
+	}
+	r.reader = csv.NewReader(src)
+	r.reader.FieldsPerRecord = len(columns)
+	r.reader.ReuseRecord = true
+	return r, nil
+}
+
+func (r *InventoryReader) Next() (Record, error) {
+	for {
+		fields, err := r.reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return Record{}, &RecordError{Line: uint64(parseErr.StartLine), Err: parseErr.Err}
+			}
+			return Record{}, err
+		}
+		line, _ := r.reader.FieldPos(0)
+		if r.isLatest >= 0 && fields[r.isLatest] == "false" {
+			continue
+		}
+		if r.deleteMarker >= 0 && fields[r.deleteMarker] == "true" {
+			continue
+		}
+		object, err := r.object(fields)
+		if err != nil {
+			return Record{}, &RecordError{Line: uint64(line), Err: err}
+		}
+		if r.filter.Match(object) {
+			return Record{Line: uint64(line), Id: object.Key}, nil
+		}
+	}
+}
+
+func (r *InventoryReader) object(fields []string) (InventoryObject, error) {
+	var object InventoryObject
+	// inventory keys are url encoded
+	key, err := url.QueryUnescape(fields[r.key])
+	if err != nil {
+		return object, fmt.Errorf("key %q: %w", fields[r.key], err)
+	}
+	object.Key = key
+	if r.size >= 0 && fields[r.size] != "" {
+		if object.Size, err = strconv.ParseInt(fields[r.size], 10, 64); err != nil {
+			return object, fmt.Errorf("size %q: %w", fields[r.size], err)
+		}
+	}
+	if r.lastModified >= 0 && fields[r.lastModified] != "" {
+		if object.LastModified, err = time.Parse(time.RFC3339, fields[r.lastModified]); err != nil {
+			return object, fmt.Errorf("last modified %q: %w", fields[r.lastModified], err)
+		}
+	}
+	return object, nil
+}
+
+// NewInventoryInputs makes input of every data file listed in manifest. Data
+// files are looked up in dataDir, by default in "data" directory next to
+// dated directory of manifest, as "aws s3 sync" of inventory destination
+// lays them out. Only CSV inventory is supported
+func NewInventoryInputs(manifestPath, dataDir string, filter InventoryFilter) ([]Input, error) {
+	manifest, err := ReadInventoryManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.FileFormat != InventoryFormatCSV {
+		return nil, fmt.Errorf("inventory %s format %q is not supported, configure %s inventory report", manifestPath, manifest.FileFormat, InventoryFormatCSV)
+	}
+	if dataDir == "" {
+		dataDir = filepath.Join(filepath.Dir(filepath.Dir(manifestPath)), "data")
+	}
+	columns := manifest.Columns()
+	inputs := make([]Input, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		dataPath := filepath.Join(dataDir, path.Base(file.Key))
+		if _, err := os.Stat(dataPath); err != nil {
+			return nil, fmt.Errorf("inventory data file %s: %w", file.Key, err)
+		}
+		inputs = append(inputs, Input{
+			Name: dataPath,
+			Open: func() (io.ReadCloser, error) { return OpenInput(dataPath) },
+			NewReader: func(src io.Reader) (RecordReader, error) {
+				return NewInventoryReader(src, columns, filter)
+			},
+		})
+	}
+	return inputs, nil
+}
@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const inventorySchema = "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate"
+
+func WriteInventory(t *testing.T, format string, dataFiles map[string]string) string {
+	root := filepath.Join(t.TempDir(), "src-bucket", "daily")
+	manifestDir := filepath.Join(root, "2024-01-01T01-00Z")
+	require.NoError(t, os.MkdirAll(manifestDir, 0755), "mkdir manifest dir")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "data"), 0755), "mkdir data dir")
+
+	manifest := `{"sourceBucket":"src-bucket","fileFormat":"` + format + `","fileSchema":"` + inventorySchema + `","files":[`
+	for _, name := range []string{"a.csv.gz", "b.csv.gz"} {
+		data, ok := dataFiles[name]
+		if !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write([]byte(data))
+		writer.Close()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, "data", name), buf.Bytes(), 0644), "write %s", name)
+		if manifest[len(manifest)-1] != '[' {
+			manifest += ","
+		}
+		manifest += `{"key":"inv/src-bucket/daily/data/` + name + `","size":1}`
+	}
+	manifest += "]}"
+	manifestPath := filepath.Join(manifestDir, "manifest.json")
+	require.NoError(t, ioutil.WriteFile(manifestPath, []byte(manifest), 0644), "write manifest")
+	return manifestPath
+}
+
+func TestTableInventory(t *testing.T) {
+	manifestPath := WriteInventory(t, InventoryFormatCSV, map[string]string{
+		"a.csv.gz": `"src-bucket","users/1","v1","true","false","10","2023-06-01T00:00:00.000Z"
+"src-bucket","users/old","v1","false","false","10","2023-06-01T00:00:00.000Z"
+"src-bucket","users/deleted","v2","true","true","","2023-06-01T00:00:00.000Z"
+"src-bucket","tmp/2","v1","true","false","10","2023-06-01T00:00:00.000Z"
+`,
+		"b.csv.gz": `"src-bucket","users/3%2Fx","v1","true","false","1000","2023-06-01T00:00:00.000Z"
+"src-bucket","users/4","v1","true","false","10","2022-06-01T00:00:00.000Z"
+`,
+	})
+	dataDir := filepath.Join(filepath.Dir(filepath.Dir(manifestPath)), "data")
+	inventoryInputs := func(filter InventoryFilter) []Input {
+		inputs, err := NewInventoryInputs(manifestPath, "", filter)
+		require.NoError(t, err, "inventory inputs")
+		return inputs
+	}
+
+	tests := []TestCase{
+		{Desc: "latest objects of all data files",
+			Inputs: inventoryInputs(InventoryFilter{}),
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "users/1", Source: filepath.Join(dataDir, "a.csv.gz")},
+				GeneratorValue{Line: 4, Id: "tmp/2", Source: filepath.Join(dataDir, "a.csv.gz")},
+				GeneratorValue{Line: 1, Id: "users/3/x", Source: filepath.Join(dataDir, "b.csv.gz")},
+				GeneratorValue{Line: 2, Id: "users/4", Source: filepath.Join(dataDir, "b.csv.gz")},
+			},
+		},
+		{Desc: "prefix, size and last modified filters",
+			Inputs: inventoryInputs(InventoryFilter{
+				Prefix:        "users/",
+				MaxSize:       100,
+				ModifiedAfter: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			}),
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "users/1", Source: filepath.Join(dataDir, "a.csv.gz")},
+			},
+		},
+	}
+	CheckTestCases(t, tests)
+}
+
+func TestInventoryErrors(t *testing.T) {
+	_, err := NewInventoryInputs(WriteInventory(t, InventoryFormatParquet, nil), "", InventoryFilter{})
+	assert.Error(t, err, "parquet is not supported")
+
+	manifestPath := WriteInventory(t, InventoryFormatCSV, map[string]string{"a.csv.gz": ""})
+	_, err = NewInventoryInputs(manifestPath, t.TempDir(), InventoryFilter{})
+	assert.Error(t, err, "data file not found in data dir")
+
+	_, err = NewInventoryReader(bytes.NewReader(nil), []string{"Bucket", "Key"}, InventoryFilter{MinSize: 1})
+	assert.Error(t, err, "size filter without Size column")
+	_, err = NewInventoryReader(bytes.NewReader(nil), []string{"Bucket", "Size"}, InventoryFilter{})
+	assert.Error(t, err, "no Key column")
+}
@@ -0,0 +1,314 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultMaxLineLength is longest line LineReader accepts, longer lines are
+// bad records
+const DefaultMaxLineLength = 1 << 20
+
+var ErrLineTooLong = errors.New("line too long")
+
+const (
+	FormatLines = "lines"
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+	FormatJSONL = "jsonl"
+)
+
+// Record is one file id read from input. Line is line number in input where
+// record starts, it is what error reports refer to
+type Record struct {
+	Line   uint64
+	Id     string
+	Quoted bool   // "quoted" id of lines input, spaces in it are part of key
+	Key    string // row key of database source, see SQLSource
+}
+
+// RecordReader returns io.EOF after last record. Error for broken record
+// comes as *RecordError, any other error means input can not be read further
+type RecordReader interface {
+	Next() (Record, error)
+}
+
+type RecordError struct {
+	Line uint64
+	Err  error
+	Text string // broken record as read, if reader has it
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// LineReader treats every line as file id. Line starting with double quote is
+// Go quoted string, so keys with spaces, newlines or leading quote can be given
+// like "my key\n". Line over MaxLength is RecordError with ErrLineTooLong and
+// reading goes on from the next line
+type LineReader struct {
+	MaxLength int // DefaultMaxLineLength when 0
+	reader    *bufio.Reader
+	buf       []byte
+	line      uint64
+	offset    int64 // bytes of src read, where next line starts
+	raw       bool  // lines as is, no unquoting
+}
+
+func NewLineReader(src io.Reader) *LineReader {
+	return &LineReader{reader: bufio.NewReader(src)}
+}
+
+// readLine returns line without line break, cut to MaxLength if too long
+func (r *LineReader) readLine() ([]byte, bool, error) {
+	max := r.MaxLength
+	if max <= 0 {
+		max = DefaultMaxLineLength
+	}
+	r.buf = r.buf[:0]
+	tooLong, read := false, 0
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		read += len(chunk)
+		r.offset += int64(len(chunk))
+		if !tooLong {
+			r.buf = append(r.buf, chunk...)
+			if n := len(bytes.TrimRight(r.buf, "\r\n")); n > max {
+				tooLong = true
+				r.buf = r.buf[:max]
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && read > 0 {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		break
+	}
+	if !tooLong {
+		r.buf = bytes.TrimSuffix(r.buf, []byte("\n"))
+		r.buf = bytes.TrimSuffix(r.buf, []byte("\r"))
+	}
+	return r.buf, tooLong, nil
+}
+
+func (r *LineReader) Next() (Record, error) {
+	line, tooLong, err := r.readLine()
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("scan error: %s", err)
+	}
+	r.line++
+	text := string(line)
+	if tooLong {
+		return Record{}, &RecordError{Line: r.line, Err: ErrLineTooLong, Text: text}
+	}
+	if r.raw || !strings.HasPrefix(text, `"`) {
+		return Record{Line: r.line, Id: text}, nil
+	}
+	id, err := strconv.Unquote(strings.TrimRightFunc(text, unicode.IsSpace))
+	if err != nil {
+		return Record{}, &RecordError{Line: r.line, Err: fmt.Errorf("bad quoted id %s", text), Text: text}
+	}
+	return Record{Line: r.line, Id: id, Quoted: true}, nil
+}
+
+// Offset is byte offset in src where line after last read one starts
+func (r *LineReader) Offset() int64 {
+	return r.offset
+}
+
+// QuoteId formats id for lines input: ids LineReader would misread come quoted
+func QuoteId(id string) string {
+	if strings.HasPrefix(id, `"`) || strings.IndexFunc(id, unicode.IsSpace) >= 0 || !strconv.CanBackquote(id) {
+		return strconv.Quote(id)
+	}
+	return id
+}
+
+// CSVReader takes file id from Column, which is either 1-based column number
+// or column name from header line
+type CSVReader struct {
+	reader  *csv.Reader
+	Column  string
+	Header  bool
+	index   int
+	started bool
+}
+
+func NewCSVReader(src io.Reader, comma rune, column string, header bool) *CSVReader {
+	reader := csv.NewReader(src)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if comma == '\t' {
+		// tsv exports rarely follow csv quoting rules
+		reader.LazyQuotes = true
+	}
+	return &CSVReader{reader: reader, Column: column, Header: header}
+}
+
+func (r *CSVReader) init() error {
+	r.started = true
+	if number, err := strconv.Atoi(r.Column); err == nil {
+		if number < 1 {
+			return fmt.Errorf("column number %d, first column is 1", number)
+		}
+		r.index = number - 1
+		if r.Header {
+			if _, err := r.reader.Read(); err != nil && err != io.EOF {
+				return r.wrap(err)
+			}
+		}
+		return nil
+	}
+	if !r.Header {
+		return fmt.Errorf("column %q given by name but input has no header", r.Column)
+	}
+	header, err := r.reader.Read()
+	if err == io.EOF {
+		return err
+	}
+	if err != nil {
+		return r.wrap(err)
+	}
+	for i, name := range header {
+		if strings.TrimSpace(name) == r.Column {
+			r.index = i
+			return nil
+		}
+	}
+	return fmt.Errorf("column %q not found in header %q", r.Column, header)
+}
+
+func (r *CSVReader) wrap(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &RecordError{Line: uint64(parseErr.StartLine), Err: parseErr.Err}
+	}
+	return err
+}
+
+func (r *CSVReader) Next() (Record, error) {
+	if !r.started {
+		if err := r.init(); err != nil {
+			if err == io.EOF {
+				return Record{}, err
+			}
+			// no skipping broken header, it would shift columns
+			return Record{}, fmt.Errorf("csv header: %s", err)
+		}
+	}
+	fields, err := r.reader.Read()
+	if err != nil {
+		return Record{}, r.wrap(err)
+	}
+	line, _ := r.reader.FieldPos(0)
+	if r.index >= len(fields) {
+		return Record{}, &RecordError{Line: uint64(line), Err: fmt.Errorf("no column %d in record of %d fields", r.index+1, len(fields))}
+	}
+	return Record{Line: uint64(line), Id: fields[r.index]}, nil
+}
+
+// JSONLReader takes file id from FieldPath, dot separated path of object keys
+// and array indexes like "file.id" or "items.0.key"
+type JSONLReader struct {
+	lines *LineReader
+	path  []string
+}
+
+func NewJSONLReader(src io.Reader, fieldPath string) *JSONLReader {
+	return &JSONLReader{lines: &LineReader{reader: bufio.NewReader(src), raw: true}, path: strings.Split(fieldPath, ".")}
+}
+
+func (r *JSONLReader) Next() (Record, error) {
+	record, err := r.lines.Next()
+	if err != nil || len(strings.TrimSpace(record.Id)) == 0 {
+		return Record{Line: record.Line}, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(record.Id))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return Record{}, &RecordError{Line: record.Line, Err: err, Text: record.Id}
+	}
+	id, err := lookupJSONPath(value, r.path)
+	if err != nil {
+		return Record{}, &RecordError{Line: record.Line, Err: err, Text: record.Id}
+	}
+	return Record{Line: record.Line, Id: id}, nil
+}
+
+func (r *JSONLReader) Offset() int64 {
+	return r.lines.offset
+}
+
+func lookupJSONPath(value interface{}, path []string) (string, error) {
+	for i, key := range path {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("field %q not found", strings.Join(path[:i+1], "."))
+			}
+			value = next
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return "", fmt.Errorf("no index %q in array %q", key, strings.Join(path[:i], "."))
+			}
+			value = node[idx]
+		default:
+			return "", fmt.Errorf("%q is not an object or array", strings.Join(path[:i], "."))
+		}
+	}
+	switch id := value.(type) {
+	case string:
+		return id, nil
+	case json.Number:
+		return id.String(), nil
+	}
+	return "", fmt.Errorf("field %q is not a string or number", strings.Join(path, "."))
+}
+
+// NewRecordReader builds reader for input format, empty format means lines
+func NewRecordReader(src io.Reader, format, column string, header bool, fieldPath string) (RecordReader, error) {
+	switch format {
+	case "", FormatLines:
+		return NewLineReader(src), nil
+	case FormatCSV, FormatTSV:
+		if column == "" {
+			return nil, fmt.Errorf("%s input requires column", format)
+		}
+		comma := ','
+		if format == FormatTSV {
+			comma = '\t'
+		}
+		return NewCSVReader(src, comma, column, header), nil
+	case FormatJSONL:
+		if fieldPath == "" {
+			return nil, fmt.Errorf("%s input requires field path", format)
+		}
+		return NewJSONLReader(src, fieldPath), nil
+	}
+	return nil, fmt.Errorf("unknown input format %q, expect %s, %s, %s or %s", format, FormatLines, FormatCSV, FormatTSV, FormatJSONL)
+}
@@ -0,0 +1,104 @@
+package generator
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ReadAllRecords(reader RecordReader) ([]Record, error) {
+	records := make([]Record, 0)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestCSVReaderColumnByName(t *testing.T) {
+	input := "name,file_id,size\n\"a, b\",111,10\nc,222,20\n"
+	records, err := ReadAllRecords(NewCSVReader(strings.NewReader(input), ',', "file_id", true))
+	assert.NoError(t, err, "csv read")
+	assert.Equal(t, []Record{{Line: 2, Id: "111"}, {Line: 3, Id: "222"}}, records, "column found by header name")
+}
+
+func TestCSVReaderMultilineKeepsLineNumber(t *testing.T) {
+	input := "1,\"multi\nline\"\n2,x\n"
+	records, err := ReadAllRecords(NewCSVReader(strings.NewReader(input), ',', "1", false))
+	assert.NoError(t, err, "csv read")
+	assert.Equal(t, []Record{{Line: 1, Id: "1"}, {Line: 3, Id: "2"}}, records, "line where record starts")
+}
+
+func TestCSVReaderErrors(t *testing.T) {
+	_, err := ReadAllRecords(NewCSVReader(strings.NewReader("a,b\n1,2\n"), ',', "c", true))
+	assert.Error(t, err, "unknown column name")
+	_, err = ReadAllRecords(NewCSVReader(strings.NewReader("1,2\n"), ',', "id", false))
+	assert.Error(t, err, "column name without header")
+	_, err = ReadAllRecords(NewCSVReader(strings.NewReader("1,2\n"), ',', "0", false))
+	assert.Error(t, err, "column numbers start from 1")
+
+	records, err := ReadAllRecords(NewCSVReader(strings.NewReader("1,2\n3\n"), ',', "2", false))
+	assert.Equal(t, []Record{{Line: 1, Id: "2"}}, records, "records before short one")
+	if recordErr, ok := err.(*RecordError); assert.True(t, ok, "record error for short record") {
+		assert.Equal(t, uint64(2), recordErr.Line, "line of short record")
+	}
+
+	_, err = ReadAllRecords(NewCSVReader(strings.NewReader("1,2\n\"3,4\n"), ',', "1", false))
+	if recordErr, ok := err.(*RecordError); assert.True(t, ok, "record error for broken quotes") {
+		assert.Equal(t, uint64(2), recordErr.Line, "line of broken record")
+	}
+}
+
+func TestTSVReaderLazyQuotes(t *testing.T) {
+	input := "id\tcomment\n111\tsays \"hi\"\n"
+	records, err := ReadAllRecords(NewCSVReader(strings.NewReader(input), '\t', "id", true))
+	assert.NoError(t, err, "tsv read")
+	assert.Equal(t, []Record{{Line: 2, Id: "111"}}, records, "tsv column")
+}
+
+func TestJSONLReader(t *testing.T) {
+	input := `{"file":{"id":"111"}}` + "\n\n" + `{"file":{"id":222}}` + "\n" + `{"file":{"ids":["333"]}}`
+	reader := NewJSONLReader(strings.NewReader(input), "file.id")
+	records, err := ReadAllRecords(reader)
+	assert.Equal(t, []Record{{Line: 1, Id: "111"}, {Line: 2, Id: ""}, {Line: 3, Id: "222"}}, records, "ids and empty line")
+	if recordErr, ok := err.(*RecordError); assert.True(t, ok, "record error for missing field") {
+		assert.Equal(t, uint64(4), recordErr.Line, "line of broken record")
+	}
+
+	records, err = ReadAllRecords(NewJSONLReader(strings.NewReader(`{"ids":["a","b"]}`), "ids.1"))
+	assert.NoError(t, err, "array index in path")
+	assert.Equal(t, []Record{{Line: 1, Id: "b"}}, records, "id from array")
+
+	_, err = ReadAllRecords(NewJSONLReader(strings.NewReader(`{"id":`), "id"))
+	assert.Error(t, err, "broken json")
+}
+
+func TestNewRecordReaderFormats(t *testing.T) {
+	for _, format := range []string{"", FormatLines} {
+		_, err := NewRecordReader(strings.NewReader(""), format, "", false, "")
+		assert.NoError(t, err, "format %q", format)
+	}
+	_, err := NewRecordReader(strings.NewReader(""), FormatCSV, "", false, "")
+	assert.Error(t, err, "csv without column")
+	_, err = NewRecordReader(strings.NewReader(""), FormatJSONL, "", false, "")
+	assert.Error(t, err, "jsonl without field path")
+	_, err = NewRecordReader(strings.NewReader(""), "xml", "", false, "")
+	assert.Error(t, err, "unknown format")
+}
+
+func TestQuoteId(t *testing.T) {
+	for _, id := range []string{"plain/key.txt", "with space", "\"leading quote", "new\nline", "\x00", "файл"} {
+		records, err := ReadAllRecords(NewLineReader(strings.NewReader(QuoteId(id) + "\n")))
+		if assert.NoError(t, err, "read back %q", id) && assert.Len(t, records, 1, "one record for %q", id) {
+			assert.Equal(t, id, records[0].Id, "id read back")
+		}
+	}
+	assert.Equal(t, "plain/key.txt", QuoteId("plain/key.txt"), "plain id as is")
+}
@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const (
+	ShardByHash = "hash" // fnv-1a of id, duplicates of id land to the same shard
+	ShardByLine = "line" // record number modulo shard count, ignores id
+)
+
+// Shard selects ids of one of Count processes reading the same inputs.
+// Index is 1-based, shard 1/4 is the first of four
+type Shard struct {
+	Index uint64
+	Count uint64
+	By    string // hash (default) or line
+}
+
+// ParseShard reads "i/N" spec, empty spec means no sharding and returns nil
+func ParseShard(spec, by string) (*Shard, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("shard %q, expect i/N", spec)
+	}
+	index, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("shard %q index: %w", spec, err)
+	}
+	count, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("shard %q count: %w", spec, err)
+	}
+	if count == 0 || index < 1 || index > count {
+		return nil, fmt.Errorf("shard %q, index must be from 1 to count", spec)
+	}
+	switch by {
+	case "":
+		by = ShardByHash
+	case ShardByHash, ShardByLine:
+	default:
+		return nil, fmt.Errorf("unknown shard mode %q, expect %s or %s", by, ShardByHash, ShardByLine)
+	}
+	return &Shard{Index: index, Count: count, By: by}, nil
+}
+
+func (shard *Shard) String() string {
+	return fmt.Sprintf("%d/%d", shard.Index, shard.Count)
+}
+
+// Owns tells whether id read as record number position (1-based, counted
+// over all inputs) belongs to shard
+func (shard *Shard) Owns(id string, position uint64) bool {
+	if shard.By == ShardByLine {
+		return (position-1)%shard.Count == shard.Index-1
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	return hash.Sum64()%shard.Count == shard.Index-1
+}
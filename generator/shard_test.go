@@ -0,0 +1,74 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShard(t *testing.T) {
+	shard, err := ParseShard("", "")
+	assert.NoError(t, err, "no sharding")
+	assert.Nil(t, shard, "no sharding")
+
+	shard, err = ParseShard("2/8", "")
+	if assert.NoError(t, err, "shard parsed") {
+		assert.Equal(t, &Shard{Index: 2, Count: 8, By: ShardByHash}, shard, "hash by default")
+		assert.Equal(t, "2/8", shard.String(), "shard string")
+	}
+	for _, spec := range []string{"2", "0/8", "9/8", "1/0", "a/8", "1/b"} {
+		_, err := ParseShard(spec, "")
+		assert.Error(t, err, "bad spec %s", spec)
+	}
+	_, err = ParseShard("1/2", "random")
+	assert.Error(t, err, "unknown mode")
+}
+
+func TestShardsSplitIds(t *testing.T) {
+	for _, by := range []string{ShardByHash, ShardByLine} {
+		owners := make(map[string]int)
+		for index := uint64(1); index <= 3; index++ {
+			shard := &Shard{Index: index, Count: 3, By: by}
+			for position := uint64(1); position <= 100; position++ {
+				if shard.Owns(fmt.Sprintf("id-%d", position), position) {
+					owners[fmt.Sprintf("id-%d", position)]++
+				}
+			}
+		}
+		assert.Len(t, owners, 100, "every id has owner when by %s", by)
+		for id, count := range owners {
+			assert.Equal(t, 1, count, "%s has single owner when by %s", id, by)
+		}
+	}
+}
+
+func TestTableShard(t *testing.T) {
+	input := strings.Repeat("a\nb\n", 2) + "c\n"
+	tests := []TestCase{
+		{Desc: "line modulo over all inputs",
+			Instance: &Generator{Shard: &Shard{Index: 2, Count: 2, By: ShardByLine}},
+			Inputs:   []Input{StringInput("1.txt", "1\n2\n3\n"), StringInput("2.txt", "4\n5\n")},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 2, Id: "2", Source: "1.txt"},
+				GeneratorValue{Line: 1, Id: "4", Source: "2.txt"},
+			},
+		},
+		{Desc: "same id goes to same shard",
+			Instance: &Generator{Shard: &Shard{Index: 1, Count: 1, By: ShardByHash}},
+			Input:    input,
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "a"}, GeneratorValue{Line: 2, Id: "b"},
+				GeneratorValue{Line: 3, Id: "a"}, GeneratorValue{Line: 4, Id: "b"},
+				GeneratorValue{Line: 5, Id: "c"},
+			},
+		},
+		{Desc: "bad id reported by owner shard only",
+			Instance:  &Generator{Shard: &Shard{Index: 1, Count: 2, By: ShardByLine}},
+			Input:     "1\n2 2\n3",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 3, Id: "3"}},
+		},
+	}
+	CheckTestCases(t, tests)
+}
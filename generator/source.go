@@ -0,0 +1,26 @@
+package generator
+
+import (
+	"context"
+)
+
+// Source gives records of input that is not a byte stream, like bucket
+// listing or database query. Open is called when generator gets to the
+// input, Close after its last record or on error
+type Source interface {
+	Open(ctx context.Context) (RecordReader, error)
+	Close() error
+}
+
+// ReaderSource adapts ready RecordReader to Source
+type ReaderSource struct {
+	Reader RecordReader
+}
+
+func (source ReaderSource) Open(context.Context) (RecordReader, error) {
+	return source.Reader, nil
+}
+
+func (source ReaderSource) Close() error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package generator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultSQLPageSize = 10000
+
+// SQLSource reads ids from database table page by page with keyset
+// pagination: every page query starts after last KeyColumn value of previous
+// page, so there is no OFFSET scan and rows added during run are not missed.
+// Record line is row number counted from Cursor
+type SQLSource struct {
+	DB          *sql.DB
+	Table       string
+	IdColumn    string
+	KeyColumn   string // unique and ordered, IdColumn when empty
+	Where       string // extra condition, like "deleted_at > '2024-01-01'"
+	PageSize    int    // DefaultSQLPageSize when 0
+	Placeholder string // "?" (sqlite, mysql) or "$" (postgres)
+	Cursor      string // key to start after, empty starts from the first row
+	// CursorFile keeps key to resume from, written by SaveCursor. Saved
+	// cursor is used when Cursor is empty
+	CursorFile string
+	CloseDB    bool // Close closes DB too, for DB opened for this source only
+
+	ctx      context.Context
+	rows     *sql.Rows
+	pageRows int
+	last     interface{} // key of last row read
+	line     uint64
+}
+
+func (source *SQLSource) Open(ctx context.Context) (RecordReader, error) {
+	if source.Table == "" || source.IdColumn == "" {
+		return nil, errors.New("sql source requires table and id column")
+	}
+	source.ctx = ctx
+	if source.Cursor == "" && source.CursorFile != "" {
+		data, err := ioutil.ReadFile(source.CursorFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("sql cursor: %w", err)
+		}
+		source.Cursor = strings.TrimSpace(string(data))
+	}
+	if source.Cursor != "" {
+		source.last = source.Cursor
+	}
+	if err := source.query(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (source *SQLSource) Close() error {
+	var err error
+	if source.rows != nil {
+		err = source.rows.Close()
+	}
+	if source.CloseDB {
+		if dbErr := source.DB.Close(); err == nil {
+			err = dbErr
+		}
+	}
+	return err
+}
+
+// SaveCursor keeps key of done row in CursorFile. Done row is the last one
+// finished together with every row before it, see Progress, so rows read but
+// not finished when run stopped are read again. Row without key, like one of
+// NULL id, leaves cursor where it was
+func (source *SQLSource) SaveCursor(done Mark) error {
+	if source.CursorFile == "" || done.Key == "" {
+		return nil
+	}
+	return saveCursor(source.CursorFile, done.Key)
+}
+
+func (source *SQLSource) keyColumn() string {
+	if source.KeyColumn == "" {
+		return source.IdColumn
+	}
+	return source.KeyColumn
+}
+
+func (source *SQLSource) pageSize() int {
+	if source.PageSize <= 0 {
+		return DefaultSQLPageSize
+	}
+	return source.PageSize
+}
+
+// PageQuery is query of page after key, without key for the first page
+func (source *SQLSource) PageQuery(afterKey bool) string {
+	conditions := make([]string, 0, 2)
+	if source.Where != "" {
+		conditions = append(conditions, "("+source.Where+")")
+	}
+	if afterKey {
+		placeholder := "?"
+		if source.Placeholder == "$" {
+			placeholder = "$1"
+		}
+		conditions = append(conditions, source.keyColumn()+" > "+placeholder)
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s", source.keyColumn(), source.IdColumn, source.Table)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return query + fmt.Sprintf(" ORDER BY %s LIMIT %d", source.keyColumn(), source.pageSize())
+}
+
+func (source *SQLSource) query() error {
+	if source.rows != nil {
+		source.rows.Close()
+	}
+	args := []interface{}{}
+	if source.last != nil {
+		args = append(args, source.last)
+	}
+	rows, err := source.DB.QueryContext(source.ctx, source.PageQuery(source.last != nil), args...)
+	if err != nil {
+		return fmt.Errorf("sql query: %w", err)
+	}
+	source.rows, source.pageRows = rows, 0
+	return nil
+}
+
+func (source *SQLSource) Next() (Record, error) {
+	for {
+		if source.rows.Next() {
+			var key interface{}
+			var id sql.NullString
+			if err := source.rows.Scan(&key, &id); err != nil {
+				return Record{}, fmt.Errorf("sql scan: %w", err)
+			}
+			source.line++
+			source.pageRows++
+			source.last = key
+			if !id.Valid {
+				return Record{}, &RecordError{Line: source.line, Err: fmt.Errorf("%s is NULL", source.IdColumn), Text: CursorString(key)}
+			}
+			return Record{Line: source.line, Id: id.String, Key: CursorString(key)}, nil
+		}
+		if err := source.rows.Err(); err != nil {
+			return Record{}, fmt.Errorf("sql rows: %w", err)
+		}
+		if source.pageRows < source.pageSize() {
+			return Record{}, io.EOF
+		}
+		if err := source.query(); err != nil {
+			return Record{}, err
+		}
+	}
+}
+
+// CursorString formats key the way it is kept in cursor file
+func CursorString(key interface{}) string {
+	switch value := key.(type) {
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(key)
+}
+
+func saveCursor(path, cursor string) error {
+	return replaceFile(path, []byte(cursor+"\n"))
+}
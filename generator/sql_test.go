@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func NewTestDB(t *testing.T, rows int) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "meta.db"))
+	require.NoError(t, err, "open sqlite")
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec("CREATE TABLE deleted_files (id INTEGER PRIMARY KEY, file_id TEXT, bucket TEXT)")
+	require.NoError(t, err, "create table")
+	for i := 1; i <= rows; i++ {
+		bucket := "a"
+		if i%2 == 0 {
+			bucket = "b"
+		}
+		_, err = db.Exec("INSERT INTO deleted_files (id, file_id, bucket) VALUES (?, ?, ?)", i, fmt.Sprintf("file %d", i), bucket)
+		require.NoError(t, err, "insert row")
+	}
+	return db
+}
+
+func ReadSource(t *testing.T, source Source) []Record {
+	reader, err := source.Open(context.Background())
+	require.NoError(t, err, "open source")
+	defer source.Close()
+	records, err := ReadAllRecords(reader)
+	assert.NoError(t, err, "read source")
+	return records
+}
+
+func TestSQLSourcePageQuery(t *testing.T) {
+	source := &SQLSource{Table: "t", IdColumn: "file_id", KeyColumn: "id", Where: "bucket = 'a'", PageSize: 5, Placeholder: "$"}
+	assert.Equal(t, "SELECT id, file_id FROM t WHERE (bucket = 'a') ORDER BY id LIMIT 5", source.PageQuery(false), "first page")
+	assert.Equal(t, "SELECT id, file_id FROM t WHERE (bucket = 'a') AND id > $1 ORDER BY id LIMIT 5", source.PageQuery(true), "next page")
+	source = &SQLSource{Table: "t", IdColumn: "file_id"}
+	assert.Equal(t, "SELECT file_id, file_id FROM t WHERE file_id > ? ORDER BY file_id LIMIT 10000", source.PageQuery(true), "id is key by default")
+}
+
+func TestSQLSourceKeysetPages(t *testing.T) {
+	db := NewTestDB(t, 10)
+	records := ReadSource(t, &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id", KeyColumn: "id", Where: "bucket = 'b'", PageSize: 2})
+	assert.Equal(t, []Record{
+		{Line: 1, Id: "file 2", Key: "2"},
+		{Line: 2, Id: "file 4", Key: "4"},
+		{Line: 3, Id: "file 6", Key: "6"},
+		{Line: 4, Id: "file 8", Key: "8"},
+		{Line: 5, Id: "file 10", Key: "10"},
+	}, records, "all pages of filtered rows")
+
+	records = ReadSource(t, &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id", KeyColumn: "id", PageSize: 3, Cursor: "7"})
+	assert.Equal(t, []Record{
+		{Line: 1, Id: "file 8", Key: "8"},
+		{Line: 2, Id: "file 9", Key: "9"},
+		{Line: 3, Id: "file 10", Key: "10"},
+	}, records, "rows after cursor")
+}
+
+func TestSQLSourceCursorFile(t *testing.T) {
+	db := NewTestDB(t, 10)
+	cursorFile := filepath.Join(t.TempDir(), "cursor")
+	source := &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id", KeyColumn: "id", PageSize: 3, CursorFile: cursorFile}
+	reader, err := source.Open(context.Background())
+	require.NoError(t, err, "open source")
+	progress := &Progress{}
+	for i := 0; i < 7; i++ {
+		record, err := reader.Next()
+		require.NoError(t, err, "row %d", i+1)
+		progress.Read(Mark{Position: uint64(i + 1), Line: record.Line, Key: record.Key})
+	}
+	source.Close()
+	for _, position := range []uint64{1, 2, 3, 5, 6} {
+		progress.Finish(position)
+	}
+	done, _ := progress.Done()
+	require.NoError(t, source.SaveCursor(done), "cursor saved")
+	cursor, err := ioutil.ReadFile(cursorFile)
+	require.NoError(t, err, "cursor saved")
+	assert.Equal(t, "3\n", string(cursor), "cursor stops before row 4, still running")
+
+	require.NoError(t, source.SaveCursor(Mark{Position: 4}), "row without key")
+	cursor, _ = ioutil.ReadFile(cursorFile)
+	assert.Equal(t, "3\n", string(cursor), "row without key leaves cursor")
+
+	resumed := ReadSource(t, &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id", KeyColumn: "id", PageSize: 3, CursorFile: cursorFile})
+	if assert.Len(t, resumed, 7, "resumed after saved cursor") {
+		assert.Equal(t, "file 4", resumed[0].Id, "first resumed row")
+	}
+}
+
+func TestSQLSourceCloseDB(t *testing.T) {
+	db := NewTestDB(t, 1)
+	ReadSource(t, &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id"})
+	assert.NoError(t, db.Ping(), "shared db left open")
+	ReadSource(t, &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id", CloseDB: true})
+	assert.Error(t, db.Ping(), "own db closed")
+}
+
+func TestTableSQLSource(t *testing.T) {
+	db := NewTestDB(t, 3)
+	_, err := db.Exec("INSERT INTO deleted_files (id, file_id) VALUES (4, NULL)")
+	require.NoError(t, err, "insert NULL id")
+	tests := []TestCase{
+		{Desc: "sql source with NULL id",
+			Instance: &Generator{InvalidPolicy: InvalidSkip, Validators: []Validator{UTF8Validator{}}}, // keys have spaces
+			Inputs:   []Input{{Name: "sqlite", Source: &SQLSource{DB: db, Table: "deleted_files", IdColumn: "file_id", KeyColumn: "id", PageSize: 2}}},
+			WantValue: []GeneratorValue{
+				GeneratorValue{Line: 1, Id: "file 1", Source: "sqlite"},
+				GeneratorValue{Line: 2, Id: "file 2", Source: "sqlite"},
+				GeneratorValue{Line: 3, Id: "file 3", Source: "sqlite"},
+			},
+			WantError: []GeneratorError{GeneratorError{Line: 4, Err: fmt.Errorf("file_id is NULL"), Source: "sqlite", Skipped: true}},
+		},
+	}
+	CheckTestCases(t, tests)
+}
@@ -0,0 +1,132 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxS3KeyLength is S3 limit on key length in bytes of UTF-8
+const MaxS3KeyLength = 1024
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Validator rejects record with file id not worth sending to workers
+type Validator interface {
+	Validate(record Record) error
+}
+
+// NoSpaceValidator rejects unquoted ids with whitespace: usually it is a line
+// with more than one field, not a key
+type NoSpaceValidator struct{}
+
+func (NoSpaceValidator) Validate(record Record) error {
+	if !record.Quoted && strings.IndexFunc(record.Id, unicode.IsSpace) >= 0 {
+		return errors.New("file id may not contain spaces")
+	}
+	return nil
+}
+
+type RegexValidator struct {
+	Re *regexp.Regexp
+}
+
+func (v RegexValidator) Validate(record Record) error {
+	if !v.Re.MatchString(record.Id) {
+		return fmt.Errorf("file id %q does not match %s", record.Id, v.Re)
+	}
+	return nil
+}
+
+type UUIDValidator struct{}
+
+func (UUIDValidator) Validate(record Record) error {
+	if !uuidRE.MatchString(record.Id) {
+		return fmt.Errorf("file id %q is not UUID", record.Id)
+	}
+	return nil
+}
+
+// MaxLengthValidator limits id length in bytes
+type MaxLengthValidator struct {
+	Max int
+}
+
+func (v MaxLengthValidator) Validate(record Record) error {
+	if len(record.Id) > v.Max {
+		return fmt.Errorf("file id is %d bytes long, max %d", len(record.Id), v.Max)
+	}
+	return nil
+}
+
+type UTF8Validator struct{}
+
+func (UTF8Validator) Validate(record Record) error {
+	if !utf8.ValidString(record.Id) {
+		return fmt.Errorf("file id %q is not valid UTF-8", record.Id)
+	}
+	return nil
+}
+
+// DefaultValidators are used when Generator.Validators is nil
+func DefaultValidators() []Validator {
+	return []Validator{NoSpaceValidator{}}
+}
+
+// ParseValidator builds validator from spec: no_space, uuid, utf8,
+// max_length[:N] (S3 key limit by default) or regex:EXPR
+func ParseValidator(spec string) (Validator, error) {
+	name, arg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+	switch name {
+	case "no_space":
+		return NoSpaceValidator{}, nil
+	case "uuid":
+		return UUIDValidator{}, nil
+	case "utf8":
+		return UTF8Validator{}, nil
+	case "max_length":
+		if arg == "" {
+			return MaxLengthValidator{Max: MaxS3KeyLength}, nil
+		}
+		max, err := strconv.Atoi(arg)
+		if err != nil || max < 1 {
+			return nil, fmt.Errorf("validator %q: bad length %q", spec, arg)
+		}
+		return MaxLengthValidator{Max: max}, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("validator %q: %w", spec, err)
+		}
+		return RegexValidator{Re: re}, nil
+	}
+	return nil, fmt.Errorf("unknown validator %q, expect no_space, uuid, utf8, max_length[:N] or regex:EXPR", spec)
+}
+
+func ParseValidators(specs []string) ([]Validator, error) {
+	validators := make([]Validator, 0, len(specs))
+	for _, spec := range specs {
+		validator, err := ParseValidator(spec)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, validator)
+	}
+	return validators, nil
+}
+
+func validate(validators []Validator, record Record) error {
+	for _, validator := range validators {
+		if err := validator.Validate(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
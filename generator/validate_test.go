@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseValidators(t *testing.T) {
+	validators, err := ParseValidators([]string{"no_space", "uuid", "utf8", "max_length", "max_length:10", "regex:^a"})
+	if assert.NoError(t, err, "all validators parsed") {
+		assert.Equal(t, []Validator{
+			NoSpaceValidator{}, UUIDValidator{}, UTF8Validator{},
+			MaxLengthValidator{Max: MaxS3KeyLength}, MaxLengthValidator{Max: 10},
+		}, validators[:5], "validators")
+	}
+	for _, spec := range []string{"unknown", "max_length:0", "max_length:x", "regex:("} {
+		_, err := ParseValidator(spec)
+		assert.Error(t, err, "bad spec %s", spec)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		Validator Validator
+		Record    Record
+		Valid     bool
+	}{
+		{NoSpaceValidator{}, Record{Id: "a b"}, false},
+		{NoSpaceValidator{}, Record{Id: "a\tb"}, false},
+		{NoSpaceValidator{}, Record{Id: "a b", Quoted: true}, true},
+		{UUIDValidator{}, Record{Id: "0f8fad5b-d9cb-469f-a165-70867728950e"}, true},
+		{UUIDValidator{}, Record{Id: "0f8fad5b-d9cb-469f-a165-70867728950"}, false},
+		{UTF8Validator{}, Record{Id: "файл"}, true},
+		{UTF8Validator{}, Record{Id: "\xff"}, false},
+		{MaxLengthValidator{Max: 4}, Record{Id: "файл"}, false},
+		{MaxLengthValidator{Max: 8}, Record{Id: "файл"}, true},
+	}
+	for _, test := range tests {
+		err := test.Validator.Validate(test.Record)
+		assert.Equal(t, test.Valid, err == nil, "%T %q: %v", test.Validator, test.Record.Id, err)
+	}
+}
+
+func TestLineReaderQuoted(t *testing.T) {
+	input := "plain\n\"with space\"\n\"tab\\there\" \n\"\\\"quote\"\n\"broken\n"
+	records, err := ReadAllRecords(NewLineReader(strings.NewReader(input)))
+	assert.Equal(t, []Record{
+		{Line: 1, Id: "plain"},
+		{Line: 2, Id: "with space", Quoted: true},
+		{Line: 3, Id: "tab\there", Quoted: true},
+		{Line: 4, Id: "\"quote", Quoted: true},
+	}, records, "quoted ids unescaped")
+	var recordErr *RecordError
+	if assert.True(t, errors.As(err, &recordErr), "broken quoting") {
+		assert.Equal(t, uint64(5), recordErr.Line, "line of broken quoting")
+	}
+}
+
+func TestTableValidators(t *testing.T) {
+	tests := []TestCase{
+		{Desc: "quoted key with spaces",
+			Input:     "1\n\"2 2\"\n3",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "1"}, GeneratorValue{Line: 2, Id: "2 2"}, GeneratorValue{Line: 3, Id: "3"}},
+		},
+		{Desc: "custom validators replace default",
+			Instance:  &Generator{Validators: []Validator{MaxLengthValidator{Max: 3}}},
+			Input:     "a b\nlong",
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "a b"}},
+			WantError: []GeneratorError{GeneratorError{Line: 2, Err: errors.New("file id is 4 bytes long, max 3")}},
+		},
+		{Desc: "csv quoting is not lines quoting, no_space rejects key with spaces",
+			Inputs:    []Input{csvInput("ids.csv", "\"a b\"\nc\n")},
+			WantValue: []GeneratorValue{},
+			WantError: []GeneratorError{GeneratorError{Line: 1, Err: errors.New("file id may not contain spaces"), Source: "ids.csv"}},
+		},
+		{Desc: "validate without no_space lets csv key with spaces through",
+			Instance:  &Generator{Validators: []Validator{UTF8Validator{}}},
+			Inputs:    []Input{csvInput("ids.csv", "\"a b\"\nc\n")},
+			WantValue: []GeneratorValue{GeneratorValue{Line: 1, Id: "a b", Source: "ids.csv"}, GeneratorValue{Line: 2, Id: "c", Source: "ids.csv"}},
+		},
+	}
+	CheckTestCases(t, tests)
+}
+
+func csvInput(name, data string) Input {
+	input := StringInput(name, data)
+	input.NewReader = func(src io.Reader) (RecordReader, error) {
+		return NewCSVReader(src, ',', "1", false), nil
+	}
+	return input
+}
@@ -0,0 +1,144 @@
+package s3api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+const PhaseList = "list"
+
+// Client talks to bucket on S3 compatible Endpoint using path style urls:
+// Endpoint/Bucket/key
+type Client struct {
+	Endpoint string
+	Bucket   string
+	Auth     worker.Authenticator // usually *SignerV4, nil for anonymous access
+	Client   *http.Client
+	Timeout  time.Duration
+}
+
+func (client *Client) BucketUrl() string {
+	return strings.TrimRight(client.Endpoint, "/") + "/" + client.Bucket
+}
+
+func (client *Client) ObjectUrl(key string) string {
+	return client.BucketUrl() + "/" + uriEncode(key, false)
+}
+
+func (client *Client) httpClient() *http.Client {
+	if client.Client == nil {
+		return http.DefaultClient
+	}
+	return client.Client
+}
+
+// withTimeout limits whole request including response body read
+func (client *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if client.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, client.Timeout)
+}
+
+// do sends request and returns response with status 200 or one of expected,
+// other responses are turned into *worker.RequestError
+func (client *Client) do(ctx context.Context, phase string, req *http.Request, expected ...int) (*http.Response, error) {
+	Url := req.URL.String()
+	if client.Auth != nil {
+		if err := client.Auth.Authenticate(req); err != nil {
+			var reqErr *worker.RequestError
+			if !errors.As(err, &reqErr) {
+				reqErr = worker.NewRequestBuildError(worker.PhaseAuth, Url, err)
+			}
+			return nil, reqErr
+		}
+	}
+	resp, err := client.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, worker.NewTransportError(phase, Url, err)
+	}
+	if resp.StatusCode == 200 {
+		return resp, nil
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	return nil, worker.NewResponseError(phase, Url, resp)
+}
+
+type ObjectVersion struct {
+	Key          string    `xml:"Key"`
+	VersionId    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+	ETag         string    `xml:"ETag"`
+}
+
+type DeleteMarker struct {
+	Key          string    `xml:"Key"`
+	VersionId    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type ListVersionsInput struct {
+	Prefix          string
+	KeyMarker       string
+	VersionIdMarker string
+	MaxKeys         int
+}
+
+type ListVersionsResult struct {
+	IsTruncated         bool            `xml:"IsTruncated"`
+	NextKeyMarker       string          `xml:"NextKeyMarker"`
+	NextVersionIdMarker string          `xml:"NextVersionIdMarker"`
+	Versions            []ObjectVersion `xml:"Version"`
+	DeleteMarkers       []DeleteMarker  `xml:"DeleteMarker"`
+}
+
+// ListObjectVersions returns one page of versions and delete markers
+func (client *Client) ListObjectVersions(ctx context.Context, input ListVersionsInput) (*ListVersionsResult, error) {
+	query := url.Values{"versions": {""}}
+	if input.Prefix != "" {
+		query.Set("prefix", input.Prefix)
+	}
+	if input.KeyMarker != "" {
+		query.Set("key-marker", input.KeyMarker)
+	}
+	if input.VersionIdMarker != "" {
+		query.Set("version-id-marker", input.VersionIdMarker)
+	}
+	if input.MaxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(input.MaxKeys))
+	}
+	Url := client.BucketUrl() + "?" + query.Encode()
+
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequest("GET", Url, nil)
+	if err != nil {
+		return nil, worker.NewRequestBuildError(PhaseList, Url, err)
+	}
+	resp, err := client.do(ctx, PhaseList, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result ListVersionsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, worker.NewTransportError(PhaseList, Url, fmt.Errorf("list versions response: %w", err))
+	}
+	return &result, nil
+}
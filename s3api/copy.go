@@ -0,0 +1,247 @@
+package s3api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+const (
+	// MaxCopyObjectSize is the largest object single CopyObject request can copy
+	MaxCopyObjectSize      = 5 << 30
+	MinCopyPartSize        = 5 << 20
+	DefaultCopyPartSize    = 512 << 20
+	maxMultipartUploadPart = 10000
+)
+
+// ObjectRef points to object or its version in any bucket of the store
+type ObjectRef struct {
+	Bucket    string
+	Key       string
+	VersionId string
+}
+
+// CopySource is x-amz-copy-source header value
+func (ref ObjectRef) CopySource() string {
+	source := uriEncode(ref.Bucket+"/"+ref.Key, false)
+	if ref.VersionId != "" {
+		source += "?versionId=" + url.QueryEscape(ref.VersionId)
+	}
+	return source
+}
+
+func (ref ObjectRef) String() string {
+	if ref.VersionId == "" {
+		return fmt.Sprintf("s3://%s/%s", ref.Bucket, ref.Key)
+	}
+	return fmt.Sprintf("s3://%s/%s?versionId=%s", ref.Bucket, ref.Key, ref.VersionId)
+}
+
+// HeadObject returns size of object
+func (client *Client) HeadObject(ctx context.Context, key string) (int64, error) {
+	Url := client.ObjectUrl(key)
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequest("HEAD", Url, nil)
+	if err != nil {
+		return 0, worker.NewRequestBuildError(worker.PhaseBackup, Url, err)
+	}
+	resp, err := client.do(ctx, worker.PhaseBackup, req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, worker.NewTransportError(worker.PhaseBackup, Url, errors.New("HEAD response without Content-Length"))
+	}
+	return resp.ContentLength, nil
+}
+
+type s3ResultBody struct {
+	XMLName  xml.Name
+	Code     string `xml:"Code"`
+	Message  string `xml:"Message"`
+	ETag     string `xml:"ETag"`
+	UploadId string `xml:"UploadId"`
+}
+
+// readResult parses response body of copy and multipart requests. S3 may
+// send error with status 200 once copy started, it is treated as server error
+func readResult(Url string, resp *http.Response) (*s3ResultBody, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, worker.NewTransportError(worker.PhaseRestore, Url, err)
+	}
+	var result s3ResultBody
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return &result, nil
+	}
+	if result.XMLName.Local == "Error" {
+		return nil, &worker.RequestError{
+			Phase:      worker.PhaseRestore,
+			Url:        Url,
+			StatusCode: resp.StatusCode,
+			Code:       result.Code,
+			Class:      worker.ClassifyStatus(http.StatusInternalServerError, result.Code),
+			Err:        fmt.Errorf("copy failed: %s", result.Message),
+		}
+	}
+	return &result, nil
+}
+
+func (client *Client) request(ctx context.Context, method, Url string, body io.Reader, header http.Header) (*s3ResultBody, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequest(method, Url, body)
+	if err != nil {
+		return nil, worker.NewRequestBuildError(worker.PhaseRestore, Url, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.do(ctx, worker.PhaseRestore, req, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+	return readResult(Url, resp)
+}
+
+// CopyObject copies object up to MaxCopyObjectSize to key of client bucket
+func (client *Client) CopyObject(ctx context.Context, key string, source ObjectRef) error {
+	_, err := client.request(ctx, "PUT", client.ObjectUrl(key), nil, http.Header{"X-Amz-Copy-Source": {source.CopySource()}})
+	return err
+}
+
+// Copy uses CopyObject for objects up to 5GB and multipart upload with
+// UploadPartCopy for larger ones
+func (client *Client) Copy(ctx context.Context, key string, source ObjectRef, size, partSize int64) error {
+	if size <= MaxCopyObjectSize {
+		return client.CopyObject(ctx, key, source)
+	}
+	return client.MultipartCopy(ctx, key, source, size, partSize)
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// CopyPartSize grows part size when object does not fit 10000 parts
+func CopyPartSize(size, partSize int64) int64 {
+	if partSize <= 0 {
+		partSize = DefaultCopyPartSize
+	}
+	if partSize < MinCopyPartSize {
+		partSize = MinCopyPartSize
+	}
+	if min := (size + maxMultipartUploadPart - 1) / maxMultipartUploadPart; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+// MultipartCopy copies object of any size by parts. Upload is aborted on
+// error, so failed copy leaves no parts to pay for
+func (client *Client) MultipartCopy(ctx context.Context, key string, source ObjectRef, size, partSize int64) error {
+	Url := client.ObjectUrl(key)
+	created, err := client.request(ctx, "POST", Url+"?uploads", nil, nil)
+	if err != nil {
+		return err
+	}
+	if created.UploadId == "" {
+		return worker.NewTransportError(worker.PhaseRestore, Url, errors.New("CreateMultipartUpload response without UploadId"))
+	}
+	uploadId := url.QueryEscape(created.UploadId)
+
+	err = client.copyParts(ctx, Url, uploadId, source, size, CopyPartSize(size, partSize))
+	if err != nil {
+		// abort with fresh context: ctx may be the reason of failure
+		if _, abortErr := client.request(context.Background(), "DELETE", Url+"?uploadId="+uploadId, nil, nil); abortErr != nil {
+			return fmt.Errorf("%w (abort upload %s: %s)", err, created.UploadId, abortErr)
+		}
+	}
+	return err
+}
+
+func (client *Client) copyParts(ctx context.Context, Url, uploadId string, source ObjectRef, size, partSize int64) error {
+	complete := completeMultipartUpload{}
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		end := offset + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		header := http.Header{
+			"X-Amz-Copy-Source":       {source.CopySource()},
+			"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", offset, end)},
+		}
+		partUrl := Url + "?partNumber=" + strconv.Itoa(number) + "&uploadId=" + uploadId
+		part, err := client.request(ctx, "PUT", partUrl, nil, header)
+		if err != nil {
+			return err
+		}
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: part.ETag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return worker.NewRequestBuildError(worker.PhaseRestore, Url, err)
+	}
+	_, err = client.request(ctx, "POST", Url+"?uploadId="+uploadId, strings.NewReader(string(body)),
+		http.Header{"Content-Type": {"application/xml"}})
+	return err
+}
+
+// BucketCopier restores key by server side copy from backup bucket on the
+// same store. Source is used to find object size, Destination does the copy
+// and its credentials must allow reading the source
+type BucketCopier struct {
+	Source         *Client
+	Destination    *Client
+	KeyPrefix      string              // backup key is KeyPrefix + file id
+	SourceKey      *worker.KeyTemplate // backup key, KeyPrefix is ignored when set
+	DestinationKey *worker.KeyTemplate // restored key, file id when nil
+	PartSize       int64
+}
+
+func (copier *BucketCopier) keys(file_id string) (string, string, error) {
+	sourceKey, destinationKey := copier.KeyPrefix+file_id, file_id
+	var err error
+	if copier.SourceKey != nil {
+		if sourceKey, err = copier.SourceKey.Expand(file_id, nil); err != nil {
+			return "", "", fmt.Errorf("backup key: %w", err)
+		}
+	}
+	if copier.DestinationKey != nil {
+		if destinationKey, err = copier.DestinationKey.Expand(file_id, nil); err != nil {
+			return "", "", fmt.Errorf("destination key: %w", err)
+		}
+	}
+	return sourceKey, destinationKey, nil
+}
+
+func (copier *BucketCopier) Copy(ctx context.Context, file_id string) error {
+	sourceKey, destinationKey, err := copier.keys(file_id)
+	if err != nil {
+		return worker.NewRequestBuildError(worker.PhaseBackup, copier.Source.BucketUrl(), err)
+	}
+	size, err := copier.Source.HeadObject(ctx, sourceKey)
+	if err != nil {
+		return err
+	}
+	source := ObjectRef{Bucket: copier.Source.Bucket, Key: sourceKey}
+	return copier.Destination.Copy(ctx, destinationKey, source, size, copier.PartSize)
+}
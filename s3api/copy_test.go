@@ -0,0 +1,123 @@
+package s3api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+func NewBackupStore() (*FakeBucket, *FakeBucket) {
+	backup := &FakeBucket{Name: "backup"}
+	backup.Add(FakeEntry{Key: "prefix/users/1", VersionId: "v1", LastModified: day1, Body: "one"})
+	bucket := &FakeBucket{Name: "bucket", Store: map[string]*FakeBucket{"backup": backup}}
+	return backup, bucket
+}
+
+func TestBucketCopier(t *testing.T) {
+	backup, bucket := NewBackupStore()
+	backupServer, server := backup.Server(), bucket.Server()
+	defer backupServer.Close()
+	defer server.Close()
+	source := NewTestClient(backupServer, backupServer.URL)
+	source.Bucket = "backup"
+	copier := &BucketCopier{Source: source, Destination: NewTestClient(server, server.URL), KeyPrefix: "prefix/"}
+
+	require.NoError(t, copier.Copy(context.Background(), "users/1"), "copy")
+	latest, _ := bucket.Latest("users/1")
+	assert.Equal(t, "one", latest.Body, "copied from backup bucket")
+	assert.Equal(t, "backup/prefix/users/1", bucket.Requests[0].Header.Get("X-Amz-Copy-Source"), "copy source")
+
+	err := copier.Copy(context.Background(), "users/2")
+	assert.Error(t, err, "no backup")
+	assert.Equal(t, worker.ErrorClassPermanent, worker.ClassOf(err), "missing backup is permanent")
+}
+
+func TestBucketCopierKeyTemplates(t *testing.T) {
+	backup, bucket := NewBackupStore()
+	backupServer, server := backup.Server(), bucket.Server()
+	defer backupServer.Close()
+	defer server.Close()
+	source := NewTestClient(backupServer, backupServer.URL)
+	source.Bucket = "backup"
+	pattern := regexp.MustCompile(`^(?P<kind>[a-z]+)/(?P<n>[0-9]+)$`)
+	sourceKey, err := worker.ParseKeyTemplate("prefix/{kind}/{n}", pattern)
+	require.NoError(t, err, "source template")
+	destinationKey, err := worker.ParseKeyTemplate("{md5:2}/{n}", pattern)
+	require.NoError(t, err, "destination template")
+	copier := &BucketCopier{Source: source, Destination: NewTestClient(server, server.URL), KeyPrefix: "ignored/",
+		SourceKey: sourceKey, DestinationKey: destinationKey}
+
+	require.NoError(t, copier.Copy(context.Background(), "users/1"), "copy")
+	// md5("users/1") starts with e4
+	latest, ok := bucket.Latest("e4/1")
+	require.True(t, ok, "copied to rewritten key")
+	assert.Equal(t, "one", latest.Body, "copied from templated backup key")
+
+	err = copier.Copy(context.Background(), "Users/1")
+	assert.Error(t, err, "id does not match pattern")
+	assert.Equal(t, worker.ErrorClassPermanent, worker.ClassOf(err), "template error is permanent")
+}
+
+func TestMultipartCopy(t *testing.T) {
+	backup, bucket := NewBackupStore()
+	body := strings.Repeat("0123456789", MinCopyPartSize/10*2+1)
+	backup.Add(FakeEntry{Key: "big", VersionId: "v1", LastModified: day1, Body: body})
+	server := bucket.Server()
+	defer server.Close()
+	client := NewTestClient(server, server.URL)
+
+	source := ObjectRef{Bucket: "backup", Key: "big"}
+	require.NoError(t, client.MultipartCopy(context.Background(), "big", source, int64(len(body)), 1), "multipart copy")
+	latest, _ := bucket.Latest("big")
+	assert.Equal(t, len(body), len(latest.Body), "size")
+	assert.True(t, body == latest.Body, "parts assembled in order")
+	assert.Equal(t, 0, bucket.Uploads(), "upload completed")
+	ranges := make([]string, 0)
+	for _, req := range bucket.Requests {
+		if value := req.Header.Get("X-Amz-Copy-Source-Range"); value != "" {
+			ranges = append(ranges, value)
+		}
+	}
+	assert.Equal(t, []string{"bytes=0-5242879", "bytes=5242880-10485759", "bytes=10485760-10485769"}, ranges, "parts of minimal size")
+}
+
+func TestMultipartCopyAbort(t *testing.T) {
+	backup, bucket := NewBackupStore()
+	body := strings.Repeat("x", MinCopyPartSize+1)
+	backup.Add(FakeEntry{Key: "big", VersionId: "v1", LastModified: day1, Body: body})
+	server := bucket.Server(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("partNumber") == "2" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			next(w, r)
+		}
+	})
+	defer server.Close()
+
+	err := NewTestClient(server, server.URL).MultipartCopy(context.Background(), "big", ObjectRef{Bucket: "backup", Key: "big"}, int64(len(body)), MinCopyPartSize)
+	assert.Error(t, err, "part failed")
+	assert.True(t, worker.IsRetryable(err), "server error is retryable")
+	assert.Equal(t, 0, bucket.Uploads(), "upload aborted")
+	_, found := bucket.Latest("big")
+	assert.False(t, found, "nothing written")
+}
+
+func TestCopyPartSize(t *testing.T) {
+	assert.Equal(t, int64(DefaultCopyPartSize), CopyPartSize(6<<30, 0), "default")
+	assert.Equal(t, int64(MinCopyPartSize), CopyPartSize(6<<30, 1), "at least 5MB")
+	assert.Equal(t, int64(5<<40/10000+1), CopyPartSize(5<<40, MinCopyPartSize), "no more than 10000 parts")
+}
+
+func TestObjectRefCopySource(t *testing.T) {
+	assert.Equal(t, "bucket/a%20b/c", ObjectRef{Bucket: "bucket", Key: "a b/c"}.CopySource(), "key encoded")
+	assert.Equal(t, "bucket/k?versionId=v%2B1", ObjectRef{Bucket: "bucket", Key: "k", VersionId: "v+1"}.CopySource(), "version")
+}
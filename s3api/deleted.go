@@ -0,0 +1,139 @@
+package s3api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/mxpaul/unfuckup_s3/generator"
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+const (
+	defaultListPageSize    = 1000
+	defaultListMaxAttempts = 5
+	defaultListBackoff     = time.Second
+)
+
+// DeletedFilter selects delete markers by time they were created. Zero
+// values do not filter
+type DeletedFilter struct {
+	Prefix string
+	After  time.Time
+	Before time.Time
+}
+
+func (filter DeletedFilter) Match(marker DeleteMarker) bool {
+	if !filter.After.IsZero() && marker.LastModified.Before(filter.After) {
+		return false
+	}
+	if !filter.Before.IsZero() && !marker.LastModified.Before(filter.Before) {
+		return false
+	}
+	return true
+}
+
+// DeleteMarkerReader pages through ListObjectVersions and returns keys whose
+// latest version is delete marker. It is generator.RecordReader, record line
+// is number of delete marker in listing
+type DeleteMarkerReader struct {
+	Client      *Client
+	Filter      DeletedFilter
+	PageSize    int
+	MaxAttempts int           // page request attempts on retryable errors
+	Backoff     time.Duration // first retry delay when server gives no Retry-After, doubles every attempt
+
+	ctx       context.Context
+	page      []DeleteMarker
+	input     ListVersionsInput
+	started   bool
+	truncated bool
+	line      uint64
+}
+
+func NewDeleteMarkerReader(ctx context.Context, client *Client, filter DeletedFilter) *DeleteMarkerReader {
+	return &DeleteMarkerReader{Client: client, Filter: filter, ctx: ctx}
+}
+
+func (r *DeleteMarkerReader) Next() (generator.Record, error) {
+	for {
+		for len(r.page) > 0 {
+			marker := r.page[0]
+			r.page = r.page[1:]
+			r.line++
+			if marker.IsLatest && r.Filter.Match(marker) {
+				return generator.Record{Line: r.line, Id: marker.Key}, nil
+			}
+		}
+		if r.started && !r.truncated {
+			return generator.Record{}, io.EOF
+		}
+		if err := r.nextPage(); err != nil {
+			return generator.Record{}, err
+		}
+	}
+}
+
+func (r *DeleteMarkerReader) nextPage() error {
+	if !r.started {
+		r.started = true
+		r.input = ListVersionsInput{Prefix: r.Filter.Prefix, MaxKeys: r.PageSize}
+		if r.input.MaxKeys <= 0 {
+			r.input.MaxKeys = defaultListPageSize
+		}
+	}
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultListMaxAttempts
+	}
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := r.Client.ListObjectVersions(ctx, r.input)
+		if err == nil {
+			r.page = result.DeleteMarkers
+			r.truncated = result.IsTruncated
+			r.input.KeyMarker, r.input.VersionIdMarker = result.NextKeyMarker, result.NextVersionIdMarker
+			if r.truncated && r.input.KeyMarker == "" {
+				return fmt.Errorf("truncated versions listing without NextKeyMarker")
+			}
+			return nil
+		}
+		if !worker.IsRetryable(err) || attempt >= maxAttempts {
+			return err
+		}
+		delay := worker.RetryAfter(err)
+		if delay <= 0 {
+			backoff := r.Backoff
+			if backoff <= 0 {
+				backoff = defaultListBackoff
+			}
+			delay = backoff << (attempt - 1)
+		}
+		log.Printf("[ERR][RETRY] list versions after key %q (delay %s): %s", r.input.KeyMarker, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Input makes generator input of reader
+func (r *DeleteMarkerReader) Input() generator.Input {
+	return generator.Input{Name: fmt.Sprintf("s3://%s/%s", r.Client.Bucket, r.Filter.Prefix), Source: r}
+}
+
+// Open makes listing stop with generator
+func (r *DeleteMarkerReader) Open(ctx context.Context) (generator.RecordReader, error) {
+	r.ctx = ctx
+	return r, nil
+}
+
+func (r *DeleteMarkerReader) Close() error {
+	return nil
+}
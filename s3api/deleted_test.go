@@ -0,0 +1,101 @@
+package s3api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	day1 = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day2 = day1.Add(24 * time.Hour)
+	day3 = day2.Add(24 * time.Hour)
+)
+
+func NewVersionedBucket() *FakeBucket {
+	bucket := &FakeBucket{Name: "bucket"}
+	bucket.Add(
+		FakeEntry{Key: "users/1", VersionId: "v1", LastModified: day1, Body: "one"},
+		FakeEntry{Key: "users/1", VersionId: "d1", LastModified: day2, DeleteMarker: true},
+		FakeEntry{Key: "users/2", VersionId: "v1", LastModified: day1, Body: "two"},
+		FakeEntry{Key: "users/3", VersionId: "v1", LastModified: day1, Body: "three"},
+		FakeEntry{Key: "users/3", VersionId: "d1", LastModified: day2, DeleteMarker: true},
+		FakeEntry{Key: "users/3", VersionId: "v2", LastModified: day3, Body: "three again"},
+		FakeEntry{Key: "users/4", VersionId: "v1", LastModified: day1, Body: "four"},
+		FakeEntry{Key: "users/4", VersionId: "d1", LastModified: day3, DeleteMarker: true},
+		FakeEntry{Key: "tmp/5", VersionId: "v1", LastModified: day1, Body: "five"},
+		FakeEntry{Key: "tmp/5", VersionId: "d1", LastModified: day2, DeleteMarker: true},
+	)
+	return bucket
+}
+
+func NewTestClient(server *httptest.Server, url string) *Client {
+	return &Client{
+		Endpoint: url,
+		Bucket:   "bucket",
+		Client:   server.Client(),
+		Auth:     &SignerV4{Credentials: Credentials{AccessKeyId: "id", SecretAccessKey: "secret"}},
+	}
+}
+
+func ReadDeletedKeys(t *testing.T, reader *DeleteMarkerReader) []string {
+	keys := make([]string, 0)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return keys
+		}
+		require.NoError(t, err, "next delete marker")
+		keys = append(keys, record.Id)
+	}
+}
+
+func TestDeleteMarkerReader(t *testing.T) {
+	bucket := NewVersionedBucket()
+	server := bucket.Server()
+	defer server.Close()
+	client := NewTestClient(server, server.URL)
+
+	reader := NewDeleteMarkerReader(context.Background(), client, DeletedFilter{})
+	reader.PageSize = 2
+	assert.Equal(t, []string{"tmp/5", "users/1", "users/4"}, ReadDeletedKeys(t, reader), "keys with latest delete marker")
+	assert.Equal(t, 5, len(bucket.Requests), "listed by pages of 2")
+	for _, req := range bucket.Requests {
+		assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), "request is signed")
+	}
+
+	reader = NewDeleteMarkerReader(context.Background(), client, DeletedFilter{Prefix: "users/", After: day2, Before: day3})
+	assert.Equal(t, []string{"users/1"}, ReadDeletedKeys(t, reader), "prefix and time range")
+}
+
+func TestDeleteMarkerReaderRetry(t *testing.T) {
+	bucket := NewVersionedBucket()
+	var calls int32
+	server := bucket.Server(func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				io.WriteString(w, "<Error><Code>SlowDown</Code></Error>")
+				return
+			}
+			next(w, r)
+		}
+	})
+	defer server.Close()
+
+	reader := NewDeleteMarkerReader(context.Background(), NewTestClient(server, server.URL), DeletedFilter{})
+	reader.PageSize, reader.Backoff = 3, time.Millisecond
+	assert.Equal(t, []string{"tmp/5", "users/1", "users/4"}, ReadDeletedKeys(t, reader), "throttled page retried")
+
+	reader = NewDeleteMarkerReader(context.Background(), NewTestClient(server, server.URL+"/missing"), DeletedFilter{})
+	_, err := reader.Next()
+	assert.Error(t, err, "permanent error is not retried")
+}
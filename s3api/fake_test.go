@@ -0,0 +1,291 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeEntry is version or delete marker kept by FakeBucket
+type FakeEntry struct {
+	Key          string
+	VersionId    string
+	DeleteMarker bool
+	LastModified time.Time
+	Body         string
+}
+
+// FakeBucket is local stand-in of versioned bucket. Entries of a key go from
+// newest to oldest, like S3 lists them
+type FakeBucket struct {
+	Name string
+
+	CopyError string                 // code of error sent with status 200 to copy requests
+	Store     map[string]*FakeBucket // other buckets objects may be copied from
+
+	mu       sync.Mutex
+	entries  []FakeEntry
+	versions int
+	uploads  map[string]map[int]string
+	Requests []*http.Request
+}
+
+func (bucket *FakeBucket) Uploads() int {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	return len(bucket.uploads)
+}
+
+// source finds body of object copy source points to
+func (bucket *FakeBucket) source(copySource string) (string, bool) {
+	sourceUrl, err := url.Parse("/" + copySource)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(sourceUrl.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	source := bucket
+	if parts[0] != bucket.Name {
+		if source = bucket.Store[parts[0]]; source == nil {
+			return "", false
+		}
+	}
+	versionId := sourceUrl.Query().Get("versionId")
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	for _, entry := range source.entries {
+		if entry.Key == parts[1] && (versionId == "" || entry.VersionId == versionId) {
+			return entry.Body, !entry.DeleteMarker
+		}
+	}
+	return "", false
+}
+
+// Latest returns newest entry of key
+func (bucket *FakeBucket) Latest(key string) (FakeEntry, bool) {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	for _, entry := range bucket.entries {
+		if entry.Key == key {
+			return entry, true
+		}
+	}
+	return FakeEntry{}, false
+}
+
+func (bucket *FakeBucket) Count(key string) int {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	count := 0
+	for _, entry := range bucket.entries {
+		if entry.Key == key {
+			count++
+		}
+	}
+	return count
+}
+
+func (bucket *FakeBucket) Add(entries ...FakeEntry) {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.entries = append(bucket.entries, entries...)
+	sort.SliceStable(bucket.entries, func(i, j int) bool {
+		if bucket.entries[i].Key != bucket.entries[j].Key {
+			return bucket.entries[i].Key < bucket.entries[j].Key
+		}
+		return bucket.entries[i].LastModified.After(bucket.entries[j].LastModified)
+	})
+}
+
+func (bucket *FakeBucket) Server(middleware ...func(http.HandlerFunc) http.HandlerFunc) *httptest.Server {
+	handler := http.HandlerFunc(bucket.ServeHTTP)
+	for _, m := range middleware {
+		handler = m(handler)
+	}
+	return httptest.NewTLSServer(handler)
+}
+
+func (bucket *FakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket.mu.Lock()
+	bucket.Requests = append(bucket.Requests, r)
+	bucket.mu.Unlock()
+	if r.URL.Path != "/"+bucket.Name && !strings.HasPrefix(r.URL.Path, "/"+bucket.Name+"/") {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+bucket.Name), "/")
+	switch {
+	case key == "" && r.Method == "GET" && r.URL.Query().Has("versions"):
+		bucket.listVersions(w, r)
+	case key != "" && r.Method == "DELETE" && r.URL.Query().Has("versionId"):
+		bucket.deleteVersion(w, key, r.URL.Query().Get("versionId"))
+	case key != "" && (r.URL.Query().Has("uploads") || r.URL.Query().Has("uploadId")):
+		bucket.multipart(w, r, key)
+	case key != "" && r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		bucket.copyObject(w, key, r.Header.Get("X-Amz-Copy-Source"))
+	case key != "" && r.Method == "PUT":
+		body, _ := io.ReadAll(r.Body)
+		bucket.put(key, string(body))
+	case key != "" && (r.Method == "GET" || r.Method == "HEAD"):
+		entry, ok := bucket.Latest(key)
+		if !ok || entry.DeleteMarker {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(entry.Body)))
+		io.WriteString(w, entry.Body)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (bucket *FakeBucket) put(key, body string) string {
+	bucket.mu.Lock()
+	bucket.versions++
+	versionId := "new" + strconv.Itoa(bucket.versions)
+	bucket.mu.Unlock()
+	bucket.Add(FakeEntry{Key: key, VersionId: versionId, LastModified: time.Now(), Body: body})
+	return versionId
+}
+
+func (bucket *FakeBucket) deleteVersion(w http.ResponseWriter, key, versionId string) {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	for i, entry := range bucket.entries {
+		if entry.Key == key && entry.VersionId == versionId {
+			bucket.entries = append(bucket.entries[:i], bucket.entries[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (bucket *FakeBucket) copyObject(w http.ResponseWriter, key, source string) {
+	if bucket.CopyError != "" {
+		io.WriteString(w, "<Error><Code>"+bucket.CopyError+"</Code><Message>copy failed</Message></Error>")
+		return
+	}
+	body, ok := bucket.source(source)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+		return
+	}
+	bucket.put(key, body)
+	io.WriteString(w, "<CopyObjectResult><ETag>etag</ETag></CopyObjectResult>")
+}
+
+func (bucket *FakeBucket) multipart(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	if bucket.uploads == nil {
+		bucket.uploads = make(map[string]map[int]string)
+	}
+	if r.Method == "POST" && query.Has("uploads") {
+		bucket.versions++
+		uploadId := "upload" + strconv.Itoa(bucket.versions)
+		bucket.uploads[uploadId] = make(map[int]string)
+		io.WriteString(w, "<InitiateMultipartUploadResult><UploadId>"+uploadId+"</UploadId></InitiateMultipartUploadResult>")
+		return
+	}
+	parts, ok := bucket.uploads[query.Get("uploadId")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchUpload</Code></Error>")
+		return
+	}
+	switch r.Method {
+	case "DELETE":
+		delete(bucket.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case "PUT":
+		bucket.mu.Unlock()
+		body, found := bucket.source(r.Header.Get("X-Amz-Copy-Source"))
+		bucket.mu.Lock()
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); err != nil || !found || end >= len(body) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "<Error><Code>InvalidRequest</Code></Error>")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body[start : end+1]
+		io.WriteString(w, "<CopyPartResult><ETag>part"+strconv.Itoa(number)+"</ETag></CopyPartResult>")
+	case "POST":
+		var complete completeMultipartUpload
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var body strings.Builder
+		for _, part := range complete.Parts {
+			body.WriteString(parts[part.PartNumber])
+		}
+		delete(bucket.uploads, query.Get("uploadId"))
+		bucket.mu.Unlock()
+		bucket.put(key, body.String())
+		bucket.mu.Lock()
+		io.WriteString(w, "<CompleteMultipartUploadResult><ETag>etag</ETag></CompleteMultipartUploadResult>")
+	}
+}
+
+func (bucket *FakeBucket) listVersions(w http.ResponseWriter, r *http.Request) {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	query := r.URL.Query()
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	keyMarker, versionMarker := query.Get("key-marker"), query.Get("version-id-marker")
+	var result ListVersionsResult
+	count := 0
+	skipping := keyMarker != ""
+	for i, entry := range bucket.entries {
+		if skipping {
+			if entry.Key == keyMarker && entry.VersionId == versionMarker {
+				skipping = false
+			}
+			continue
+		}
+		if !strings.HasPrefix(entry.Key, query.Get("prefix")) {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			last := bucket.entries[i-1]
+			result.NextKeyMarker, result.NextVersionIdMarker = last.Key, last.VersionId
+			break
+		}
+		count++
+		isLatest := i == 0 || bucket.entries[i-1].Key != entry.Key
+		if entry.DeleteMarker {
+			result.DeleteMarkers = append(result.DeleteMarkers, DeleteMarker{
+				Key: entry.Key, VersionId: entry.VersionId, IsLatest: isLatest, LastModified: entry.LastModified,
+			})
+		} else {
+			result.Versions = append(result.Versions, ObjectVersion{
+				Key: entry.Key, VersionId: entry.VersionId, IsLatest: isLatest, LastModified: entry.LastModified,
+				Size: int64(len(entry.Body)),
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListVersionsResult"`
+		ListVersionsResult
+	}{ListVersionsResult: result})
+}
@@ -0,0 +1,185 @@
+// Package s3api is minimal S3 REST client: SigV4 signing and the few calls
+// restore needs, against any S3 compatible endpoint
+package s3api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm    = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat   = "20060102T150405Z"
+	sigV4DateFormat   = "20060102"
+	UnsignedPayload   = "UNSIGNED-PAYLOAD"
+	EmptyPayloadHash  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	HeaderContentHash = "X-Amz-Content-Sha256"
+	HeaderDate        = "X-Amz-Date"
+	HeaderToken       = "X-Amz-Security-Token"
+	defaultRegion     = "us-east-1"
+	defaultService    = "s3"
+)
+
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// SignerV4 signs requests with AWS Signature Version 4 in Authorization
+// header. Payload is not hashed: request body is sent as UNSIGNED-PAYLOAD
+// unless caller put its hash into X-Amz-Content-Sha256
+type SignerV4 struct {
+	Credentials Credentials
+	Region      string
+	Service     string
+	Now         func() time.Time // for tests
+}
+
+func (signer *SignerV4) String() string {
+	return fmt.Sprintf("sigv4(%s@%s)", signer.Credentials.AccessKeyId, signer.region())
+}
+
+func (signer *SignerV4) region() string {
+	if signer.Region == "" {
+		return defaultRegion
+	}
+	return signer.Region
+}
+
+func (signer *SignerV4) service() string {
+	if signer.Service == "" {
+		return defaultService
+	}
+	return signer.Service
+}
+
+// Authenticate makes SignerV4 worker.Authenticator
+func (signer *SignerV4) Authenticate(req *http.Request) error {
+	now := time.Now
+	if signer.Now != nil {
+		now = signer.Now
+	}
+	signer.Sign(req, now())
+	return nil
+}
+
+func (signer *SignerV4) Sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	scope := strings.Join([]string{now.Format(sigV4DateFormat), signer.region(), signer.service(), "aws4_request"}, "/")
+
+	if req.Header.Get(HeaderContentHash) == "" {
+		hash := EmptyPayloadHash
+		if req.Body != nil && req.Body != http.NoBody {
+			hash = UnsignedPayload
+		}
+		req.Header.Set(HeaderContentHash, hash)
+	}
+	req.Header.Set(HeaderDate, amzDate)
+	if signer.Credentials.SessionToken != "" {
+		req.Header.Set(HeaderToken, signer.Credentials.SessionToken)
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	signedHeaders, canonicalHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		req.Header.Get(HeaderContentHash),
+	}, "\n")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+signer.Credentials.SecretAccessKey), now.Format(sigV4DateFormat))
+	key = hmacSHA256(key, signer.region())
+	key = hmacSHA256(key, signer.service())
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, signer.Credentials.AccessKeyId, scope, signedHeaders, signature))
+}
+
+// canonicalHeaders signs host, content type, md5 and range and every x-amz-*
+// header. Other headers may be changed by proxies and are left unsigned
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for name, list := range req.Header {
+		lower := strings.ToLower(name)
+		switch {
+		case lower == "content-type", lower == "content-md5", lower == "range", strings.HasPrefix(lower, "x-amz-"):
+			trimmed := make([]string, 0, len(list))
+			for _, value := range list {
+				trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+			}
+			values[lower] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode is AWS flavour of percent encoding: everything except unreserved
+// characters, and slash when encoding path
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,165 @@
+package s3api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+// Strategy tells how key was restored
+type Strategy int
+
+const (
+	StrategyBackup     Strategy = iota // downloaded from backup and uploaded
+	StrategyUndelete                   // delete markers removed
+	StrategyCopy                       // previous version copied over key
+	StrategyPresent                    // key was not deleted, nothing to do
+	StrategyServerCopy                 // copied from backup bucket on the same store
+	StrategyCount
+)
+
+func (strategy Strategy) String() string {
+	switch strategy {
+	case StrategyBackup:
+		return "backup"
+	case StrategyUndelete:
+		return "undelete"
+	case StrategyCopy:
+		return "copy"
+	case StrategyPresent:
+		return "present"
+	case StrategyServerCopy:
+		return "server_copy"
+	}
+	return "unknown"
+}
+
+// ErrNoPriorVersion means bucket has nothing to restore key from
+var ErrNoPriorVersion = errors.New("no version to restore from")
+
+// KeyVersion is version or delete marker of single key
+type KeyVersion struct {
+	VersionId    string
+	DeleteMarker bool
+	IsLatest     bool
+	LastModified time.Time
+	Size         int64
+}
+
+// KeyVersions returns versions and delete markers of key, newest first
+func (client *Client) KeyVersions(ctx context.Context, key string) ([]KeyVersion, error) {
+	versions := make([]KeyVersion, 0, 2)
+	input := ListVersionsInput{Prefix: key}
+	for {
+		result, err := client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		passed := false // listing is sorted by key, "key/more" comes after key
+		for _, version := range result.Versions {
+			if version.Key == key {
+				versions = append(versions, KeyVersion{VersionId: version.VersionId, IsLatest: version.IsLatest,
+					LastModified: version.LastModified, Size: version.Size})
+			} else {
+				passed = true
+			}
+		}
+		for _, marker := range result.DeleteMarkers {
+			if marker.Key == key {
+				versions = append(versions, KeyVersion{VersionId: marker.VersionId, IsLatest: marker.IsLatest,
+					LastModified: marker.LastModified, DeleteMarker: true})
+			} else {
+				passed = true
+			}
+		}
+		if passed || !result.IsTruncated {
+			break
+		}
+		input.KeyMarker, input.VersionIdMarker = result.NextKeyMarker, result.NextVersionIdMarker
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].IsLatest != versions[j].IsLatest {
+			return versions[i].IsLatest
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+func (client *Client) DeleteObjectVersion(ctx context.Context, key, versionId string) error {
+	Url := client.ObjectUrl(key) + "?" + url.Values{"versionId": {versionId}}.Encode()
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequest("DELETE", Url, nil)
+	if err != nil {
+		return worker.NewRequestBuildError(worker.PhaseRestore, Url, err)
+	}
+	resp, err := client.do(ctx, worker.PhaseRestore, req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// VersionRestorer brings deleted key back from its previous version in the
+// same bucket
+type VersionRestorer struct {
+	Client   *Client
+	Strategy Strategy // StrategyUndelete or StrategyCopy
+	PartSize int64    // for versions over 5GB, 0 for default
+}
+
+// Restore returns ErrNoPriorVersion when bucket has no version of key, caller
+// should fall back to backup then
+func (restorer *VersionRestorer) Restore(ctx context.Context, key string) (Strategy, error) {
+	versions, err := restorer.Client.KeyVersions(ctx, key)
+	if err != nil {
+		return StrategyBackup, err
+	}
+	if len(versions) > 0 && !versions[0].DeleteMarker {
+		return StrategyPresent, nil
+	}
+	previous := -1
+	for i, version := range versions {
+		if !version.DeleteMarker {
+			previous = i
+			break
+		}
+	}
+	if previous < 0 {
+		return StrategyBackup, ErrNoPriorVersion
+	}
+
+	if restorer.Strategy == StrategyCopy {
+		version := versions[previous]
+		source := ObjectRef{Bucket: restorer.Client.Bucket, Key: key, VersionId: version.VersionId}
+		return StrategyCopy, restorer.Client.Copy(ctx, key, source, version.Size, restorer.PartSize)
+	}
+	for _, marker := range versions[:previous] {
+		if err := restorer.Client.DeleteObjectVersion(ctx, key, marker.VersionId); err != nil {
+			return StrategyUndelete, err
+		}
+	}
+	return StrategyUndelete, nil
+}
+
+// ParseStrategy accepts strategies user may choose
+func ParseStrategy(name string) (Strategy, error) {
+	for _, strategy := range []Strategy{StrategyBackup, StrategyUndelete, StrategyCopy} {
+		if strings.EqualFold(name, strategy.String()) {
+			return strategy, nil
+		}
+	}
+	return StrategyBackup, fmt.Errorf("unknown restore strategy %q, expect backup, undelete or copy", name)
+}
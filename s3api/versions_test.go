@@ -0,0 +1,88 @@
+package s3api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mxpaul/unfuckup_s3/worker"
+)
+
+func TestKeyVersions(t *testing.T) {
+	bucket := NewVersionedBucket()
+	bucket.Add(FakeEntry{Key: "users/10", VersionId: "v1", LastModified: day1, Body: "ten"})
+	server := bucket.Server()
+	defer server.Close()
+
+	versions, err := NewTestClient(server, server.URL).KeyVersions(context.Background(), "users/1")
+	require.NoError(t, err, "key versions")
+	assert.Equal(t, []KeyVersion{
+		{VersionId: "d1", DeleteMarker: true, IsLatest: true, LastModified: day2},
+		{VersionId: "v1", LastModified: day1, Size: 3},
+	}, versions, "versions of key only, newest first")
+}
+
+func TestVersionRestorerUndelete(t *testing.T) {
+	bucket := NewVersionedBucket()
+	bucket.Add(FakeEntry{Key: "users/4", VersionId: "d0", LastModified: day2, DeleteMarker: true})
+	server := bucket.Server()
+	defer server.Close()
+	restorer := &VersionRestorer{Client: NewTestClient(server, server.URL), Strategy: StrategyUndelete}
+
+	for key, body := range map[string]string{"users/1": "one", "users/4": "four"} {
+		strategy, err := restorer.Restore(context.Background(), key)
+		assert.NoError(t, err, "restore %s", key)
+		assert.Equal(t, StrategyUndelete, strategy, "strategy of %s", key)
+		latest, _ := bucket.Latest(key)
+		assert.Equal(t, body, latest.Body, "%s restored", key)
+	}
+	assert.Equal(t, 1, bucket.Count("users/4"), "every delete marker removed")
+
+	strategy, err := restorer.Restore(context.Background(), "users/2")
+	assert.NoError(t, err, "not deleted key")
+	assert.Equal(t, StrategyPresent, strategy, "nothing to do for present key")
+}
+
+func TestVersionRestorerCopy(t *testing.T) {
+	bucket := NewVersionedBucket()
+	server := bucket.Server()
+	defer server.Close()
+	restorer := &VersionRestorer{Client: NewTestClient(server, server.URL), Strategy: StrategyCopy}
+
+	strategy, err := restorer.Restore(context.Background(), "users/1")
+	assert.NoError(t, err, "restore")
+	assert.Equal(t, StrategyCopy, strategy, "strategy")
+	latest, _ := bucket.Latest("users/1")
+	assert.Equal(t, "one", latest.Body, "previous version copied")
+	assert.Equal(t, 3, bucket.Count("users/1"), "history kept")
+
+	bucket.CopyError = "InternalError"
+	_, err = restorer.Restore(context.Background(), "users/4")
+	assert.Error(t, err, "error with status 200")
+	assert.True(t, worker.IsRetryable(err), "internal error is retryable: %s", err)
+}
+
+func TestVersionRestorerNoPriorVersion(t *testing.T) {
+	bucket := NewVersionedBucket()
+	bucket.Add(FakeEntry{Key: "users/6", VersionId: "d1", LastModified: day2, DeleteMarker: true})
+	server := bucket.Server()
+	defer server.Close()
+	restorer := &VersionRestorer{Client: NewTestClient(server, server.URL), Strategy: StrategyUndelete}
+
+	for _, key := range []string{"users/6", "users/9"} {
+		_, err := restorer.Restore(context.Background(), key)
+		assert.ErrorIs(t, err, ErrNoPriorVersion, "nothing to restore %s from", key)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for name, want := range map[string]Strategy{"backup": StrategyBackup, "Undelete": StrategyUndelete, "copy": StrategyCopy} {
+		strategy, err := ParseStrategy(name)
+		assert.NoError(t, err, "parse %s", name)
+		assert.Equal(t, want, strategy, "strategy %s", name)
+	}
+	_, err := ParseStrategy("present")
+	assert.Error(t, err, "present is not a strategy to choose")
+}
@@ -0,0 +1,260 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PhaseAuth = "auth"
+
+	defaultTokenRefreshBefore = time.Minute
+	defaultTokenLifetime      = 5 * time.Minute
+	defaultPresignExpires     = 15 * time.Minute
+
+	PresignExpiresParam   = "expires"
+	PresignKeyIdParam     = "key_id"
+	PresignSignatureParam = "signature"
+
+	redacted = "REDACTED"
+)
+
+// SensitiveQueryParams are replaced with REDACTED when url gets into error message
+var SensitiveQueryParams = []string{
+	PresignSignatureParam,
+	"access_token",
+	"token",
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+}
+
+// Authenticator signs backup request before it is sent. Implementations must be
+// safe for concurrent use and must never put secrets into String() or errors
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// TokenInvalidator is Authenticator caching credentials server may revoke
+// before they expire. Invalidate drops credentials req was signed with, so
+// next Authenticate gets fresh ones
+type TokenInvalidator interface {
+	Invalidate(req *http.Request)
+}
+
+type BearerAuth struct {
+	Token string
+}
+
+func (auth *BearerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+auth.Token)
+	return nil
+}
+
+func (auth *BearerAuth) String() string {
+	return "bearer"
+}
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (auth *BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(auth.Username, auth.Password)
+	return nil
+}
+
+func (auth *BasicAuth) String() string {
+	return fmt.Sprintf("basic(%s)", auth.Username)
+}
+
+// OAuth2ClientCredentials fetches token from TokenUrl and refreshes it
+// RefreshBefore its expiration, but not earlier than half of token lifetime.
+// Token without expires_in is kept for defaultTokenLifetime
+type OAuth2ClientCredentials struct {
+	TokenUrl      string
+	ClientId      string
+	ClientSecret  string
+	Scopes        []string
+	RefreshBefore time.Duration
+	Client        *http.Client
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (auth *OAuth2ClientCredentials) String() string {
+	return fmt.Sprintf("oauth2(%s@%s)", auth.ClientId, auth.TokenUrl)
+}
+
+func (auth *OAuth2ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := auth.Token(time.Now())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns cached token or fetches new one. Concurrent callers wait for
+// single fetch instead of hammering token endpoint
+func (auth *OAuth2ClientCredentials) Token(now time.Time) (string, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	if auth.token != "" && now.Before(auth.refresh) {
+		return auth.token, nil
+	}
+	resp, err := auth.fetch()
+	if err != nil {
+		return "", err
+	}
+	lifetime := time.Duration(resp.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	refreshBefore := auth.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = defaultTokenRefreshBefore
+	}
+	// short-lived token is still reused, otherwise every request would wait
+	// for fetch under the lock
+	if refreshBefore > lifetime/2 {
+		refreshBefore = lifetime / 2
+	}
+	auth.token = resp.AccessToken
+	auth.refresh = now.Add(lifetime - refreshBefore)
+	return auth.token, nil
+}
+
+// Invalidate forgets cached token after server rejected it. Token already
+// replaced by concurrent refresh is kept
+func (auth *OAuth2ClientCredentials) Invalidate(req *http.Request) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	if token != "" && token == auth.token {
+		auth.token = ""
+	}
+}
+
+func (auth *OAuth2ClientCredentials) fetch() (*oauth2TokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", auth.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, NewRequestBuildError(PhaseAuth, auth.TokenUrl, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(auth.ClientId), url.QueryEscape(auth.ClientSecret))
+
+	client := auth.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, NewTransportError(PhaseAuth, auth.TokenUrl, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, NewResponseError(PhaseAuth, auth.TokenUrl, resp)
+	}
+	defer resp.Body.Close()
+	var token oauth2TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, NewTransportError(PhaseAuth, auth.TokenUrl, fmt.Errorf("token response: %w", err))
+	}
+	if token.AccessToken == "" {
+		return nil, &RequestError{Phase: PhaseAuth, Url: auth.TokenUrl, Class: ErrorClassPermanent,
+			Err: errors.New("token response without access_token")}
+	}
+	return &token, nil
+}
+
+// PresignAuth appends expiration and HMAC-SHA256 signature of
+// "METHOD\nPATH\nEXPIRES" to request query
+type PresignAuth struct {
+	KeyId   string
+	Secret  string
+	Expires time.Duration
+}
+
+func (auth *PresignAuth) String() string {
+	return fmt.Sprintf("presign(%s)", auth.KeyId)
+}
+
+func (auth *PresignAuth) Authenticate(req *http.Request) error {
+	auth.Sign(req.URL, req.Method, time.Now())
+	return nil
+}
+
+func (auth *PresignAuth) Sign(u *url.URL, method string, now time.Time) {
+	lifetime := auth.Expires
+	if lifetime <= 0 {
+		lifetime = defaultPresignExpires
+	}
+	expires := strconv.FormatInt(now.Add(lifetime).Unix(), 10)
+	query := u.Query()
+	query.Set(PresignExpiresParam, expires)
+	if auth.KeyId != "" {
+		query.Set(PresignKeyIdParam, auth.KeyId)
+	}
+	query.Set(PresignSignatureParam, auth.Signature(method, u.EscapedPath(), expires))
+	u.RawQuery = query.Encode()
+}
+
+func (auth *PresignAuth) Signature(method, path, expires string) string {
+	mac := hmac.New(sha256.New, []byte(auth.Secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RedactUrl hides credentials and sensitive query params
+func RedactUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for _, name := range SensitiveQueryParams {
+			if query.Has(name) {
+				query.Set(name, redacted)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// redactError hides signed url http.Client puts into its errors
+func redactError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		redactedErr := *urlErr
+		redactedErr.URL = RedactUrl(urlErr.URL)
+		return &redactedErr
+	}
+	return err
+}
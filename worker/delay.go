@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"container/heap"
+	"time"
+)
+
+// DelayQueue holds tasks until their NotBefore, so delayed retry does not
+// keep worker busy. Not safe for concurrent use
+type DelayQueue struct {
+	tasks taskHeap
+	timer *time.Timer
+}
+
+type taskHeap []WorkerTask
+
+func (h taskHeap) Len() int            { return len(h) }
+func (h taskHeap) Less(i, j int) bool  { return h[i].NotBefore.Before(h[j].NotBefore) }
+func (h taskHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(WorkerTask)) }
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	task := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return task
+}
+
+func (queue *DelayQueue) Push(task WorkerTask) {
+	heap.Push(&queue.tasks, task)
+	queue.reset()
+}
+
+func (queue *DelayQueue) Len() int {
+	return queue.tasks.Len()
+}
+
+// C fires when earliest task is due, nil channel when queue is empty
+func (queue *DelayQueue) C() <-chan time.Time {
+	if queue.timer == nil || queue.tasks.Len() == 0 {
+		return nil
+	}
+	return queue.timer.C
+}
+
+// Due removes and returns tasks whose NotBefore has come
+func (queue *DelayQueue) Due(now time.Time) []WorkerTask {
+	var due []WorkerTask
+	for queue.tasks.Len() > 0 && !queue.tasks[0].NotBefore.After(now) {
+		due = append(due, heap.Pop(&queue.tasks).(WorkerTask))
+	}
+	queue.reset()
+	return due
+}
+
+// Drain removes and returns all tasks, due or not
+func (queue *DelayQueue) Drain() []WorkerTask {
+	tasks := []WorkerTask(queue.tasks)
+	queue.tasks = nil
+	queue.reset()
+	return tasks
+}
+
+func (queue *DelayQueue) reset() {
+	if queue.timer != nil && !queue.timer.Stop() {
+		select {
+		case <-queue.timer.C:
+		default:
+		}
+	}
+	if queue.tasks.Len() == 0 {
+		return
+	}
+	wait := time.Until(queue.tasks[0].NotBefore)
+	if queue.timer == nil {
+		queue.timer = time.NewTimer(wait)
+		return
+	}
+	queue.timer.Reset(wait)
+}
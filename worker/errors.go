@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PhaseBackup  = "backup"
+	PhaseRestore = "restore"
+
+	// how much of error response body we read looking for S3 error code
+	errorBodyReadLimit = 4096
+)
+
+type ErrorClass int
+
+const (
+	ErrorClassUnknown ErrorClass = iota
+	ErrorClassNetwork
+	ErrorClassThrottled
+	ErrorClassServer
+	ErrorClassPermanent
+	ErrorClassCount
+)
+
+func (class ErrorClass) String() string {
+	switch class {
+	case ErrorClassNetwork:
+		return "network"
+	case ErrorClassThrottled:
+		return "throttled"
+	case ErrorClassServer:
+		return "server"
+	case ErrorClassPermanent:
+		return "permanent"
+	}
+	return "unknown"
+}
+
+// RequestError describes failed backup or restore request
+type RequestError struct {
+	Phase      string
+	Url        string
+	StatusCode int
+	Code       string
+	Class      ErrorClass
+	RetryAfter time.Duration
+	Redirects  []string
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	msg := fmt.Sprintf("[%s][%s] %s", e.Phase, e.Class, RedactUrl(e.Url))
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" status code %d", e.StatusCode)
+	}
+	if e.Code != "" {
+		msg += fmt.Sprintf(" code %s", e.Code)
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(" retry after %s", e.RetryAfter)
+	}
+	if len(e.Redirects) > 0 {
+		redirects := make([]string, 0, len(e.Redirects))
+		for _, Url := range e.Redirects {
+			redirects = append(redirects, RedactUrl(Url))
+		}
+		msg += fmt.Sprintf(" redirects: %s", strings.Join(redirects, " -> "))
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %s", e.Err)
+	}
+	return msg
+}
+
+func (e *RequestError) WithRedirects(chain *RedirectChain) *RequestError {
+	if chain != nil {
+		e.Redirects = chain.Urls
+	}
+	return e
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) Retryable() bool {
+	return e.Class != ErrorClassPermanent
+}
+
+// IsRetryable reports whether err worth another attempt. Errors not produced by
+// BackupClient or AmazonRestorer are considered transient
+func IsRetryable(err error) bool {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Retryable()
+	}
+	return err != nil
+}
+
+func RetryAfter(err error) time.Duration {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.RetryAfter
+	}
+	return 0
+}
+
+func ClassOf(err error) ErrorClass {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Class
+	}
+	if err == nil {
+		return ErrorClassUnknown
+	}
+	return ErrorClassNetwork
+}
+
+// NewRequestBuildError wraps error happened before request was sent
+func NewRequestBuildError(phase, url string, err error) *RequestError {
+	return &RequestError{Phase: phase, Url: url, Class: ErrorClassPermanent, Err: err}
+}
+
+func NewTransportError(phase, url string, err error) *RequestError {
+	return &RequestError{Phase: phase, Url: url, Class: ErrorClassNetwork, Err: redactError(err)}
+}
+
+type s3ErrorBody struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// NewResponseError builds error from unexpected response. Response body is
+// consumed and closed
+func NewResponseError(phase, url string, resp *http.Response) *RequestError {
+	e := &RequestError{Phase: phase, Url: url, StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, errorBodyReadLimit))
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, errorBodyReadLimit))
+	resp.Body.Close()
+	var s3err s3ErrorBody
+	if xml.Unmarshal(body, &s3err) == nil {
+		e.Code = s3err.Code
+	}
+	e.Class = ClassifyStatus(resp.StatusCode, e.Code)
+	if e.Class == ErrorClassThrottled {
+		e.RetryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return e
+}
+
+// ClassifyStatus looks at S3 error code before status, S3 sends transient
+// RequestTimeout with 400
+func ClassifyStatus(status int, code string) ErrorClass {
+	switch {
+	case status == http.StatusTooManyRequests || code == "SlowDown" || code == "Throttling" || code == "ServiceUnavailable":
+		return ErrorClassThrottled
+	case status == http.StatusServiceUnavailable:
+		return ErrorClassThrottled
+	case status == http.StatusRequestTimeout || code == "RequestTimeout":
+		return ErrorClassNetwork
+	case code == "InternalError" || code == "OperationAborted":
+		return ErrorClassServer
+	case status >= 500:
+		return ErrorClassServer
+	}
+	return ErrorClassPermanent
+}
+
+// ParseRetryAfter accepts both delay-seconds and HTTP-date forms
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
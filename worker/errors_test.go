@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		Status int
+		Code   string
+		Want   ErrorClass
+	}{
+		{http.StatusNotFound, "", ErrorClassPermanent},
+		{http.StatusForbidden, "AccessDenied", ErrorClassPermanent},
+		{http.StatusInternalServerError, "", ErrorClassServer},
+		{http.StatusServiceUnavailable, "SlowDown", ErrorClassThrottled},
+		{http.StatusServiceUnavailable, "", ErrorClassThrottled},
+		{http.StatusTooManyRequests, "", ErrorClassThrottled},
+		{http.StatusRequestTimeout, "", ErrorClassNetwork},
+		{http.StatusBadRequest, "RequestTimeout", ErrorClassNetwork},
+		{http.StatusBadRequest, "InvalidArgument", ErrorClassPermanent},
+		{http.StatusInternalServerError, "InternalError", ErrorClassServer},
+		{http.StatusConflict, "OperationAborted", ErrorClassServer},
+		{http.StatusBadRequest, "ServiceUnavailable", ErrorClassThrottled},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Want, ClassifyStatus(test.Status, test.Code), "status %d code %q", test.Status, test.Code)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, ParseRetryAfter("5", now), "delay-seconds")
+	assert.Equal(t, 30*time.Second, ParseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now), "http-date")
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(now.Add(-time.Second).Format(http.TimeFormat), now), "date in the past")
+	assert.Equal(t, time.Duration(0), ParseRetryAfter("soon", now), "garbage")
+	assert.Equal(t, time.Duration(0), ParseRetryAfter("", now), "no header")
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, IsRetryable(nil), "nil error")
+	assert.True(t, IsRetryable(fmt.Errorf("some error")), "unknown error retryable")
+	assert.False(t, IsRetryable(&RequestError{Class: ErrorClassPermanent}), "permanent")
+	assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", &RequestError{Class: ErrorClassThrottled})), "wrapped throttled")
+}
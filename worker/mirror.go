@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMirrorFailThreshold = 3
+	defaultMirrorCooldown      = 30 * time.Second
+	latencyWindow              = 1024
+	latencyRecalcEvery         = 64
+)
+
+var ErrHedgeLost = errors.New("hedged request lost the race")
+
+// BackupMirror is one of backup replicas. Mirror failing FailThreshold requests
+// in a row is considered down for Cooldown and is tried only after healthy ones
+type BackupMirror struct {
+	UrlPrefix string
+	Template  *KeyTemplate // url template used instead of UrlPrefix when set
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+type MirrorHealth struct {
+	FailThreshold int
+	Cooldown      time.Duration
+}
+
+func NewBackupMirrors(prefixes []string) []*BackupMirror {
+	mirrors := make([]*BackupMirror, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		mirrors = append(mirrors, &BackupMirror{UrlPrefix: prefix})
+	}
+	return mirrors
+}
+
+// NewBackupMirrorTemplates treats entries with { as url templates and
+// others as prefixes
+func NewBackupMirrorTemplates(entries []string, pattern *regexp.Regexp) ([]*BackupMirror, error) {
+	mirrors := make([]*BackupMirror, 0, len(entries))
+	for _, entry := range entries {
+		mirror := &BackupMirror{UrlPrefix: entry}
+		if strings.Contains(entry, "{") {
+			tmpl, err := ParseKeyTemplate(entry, pattern)
+			if err != nil {
+				return nil, err
+			}
+			mirror.Template = tmpl
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors, nil
+}
+
+func (mirror *BackupMirror) Url(file_id string) (string, error) {
+	if mirror.Template != nil {
+		return mirror.Template.Expand(file_id, EscapeKey)
+	}
+	return mirror.UrlPrefix + EscapeKey(file_id), nil
+}
+
+func (mirror *BackupMirror) Healthy(now time.Time) bool {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+	return !now.Before(mirror.downUntil)
+}
+
+func (mirror *BackupMirror) reportSuccess() {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+	mirror.fails = 0
+	mirror.downUntil = time.Time{}
+}
+
+func (mirror *BackupMirror) reportFailure(health MirrorHealth, now time.Time) {
+	threshold, cooldown := health.FailThreshold, health.Cooldown
+	if threshold <= 0 {
+		threshold = defaultMirrorFailThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultMirrorCooldown
+	}
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+	mirror.fails++
+	if mirror.fails >= threshold {
+		mirror.downUntil = now.Add(cooldown)
+	}
+}
+
+// report updates mirror health after request. Permanent errors say nothing
+// about mirror health and lost hedge race is not a failure at all
+func (mirror *BackupMirror) report(health MirrorHealth, err error) {
+	switch {
+	case err == nil:
+		mirror.reportSuccess()
+	case errors.Is(err, ErrHedgeLost):
+	case IsRetryable(err):
+		mirror.reportFailure(health, time.Now())
+	}
+}
+
+// orderMirrors keeps configured order but moves mirrors being down to the end
+func orderMirrors(mirrors []*BackupMirror, now time.Time) []*BackupMirror {
+	ordered := make([]*BackupMirror, 0, len(mirrors))
+	var down []*BackupMirror
+	for _, mirror := range mirrors {
+		if mirror.Healthy(now) {
+			ordered = append(ordered, mirror)
+		} else {
+			down = append(down, mirror)
+		}
+	}
+	return append(ordered, down...)
+}
+
+// pickMirrorError prefers retryable error: if any mirror failed temporarily
+// file is worth another try
+func pickMirrorError(errs []error) error {
+	for _, err := range errs {
+		if IsRetryable(err) {
+			return err
+		}
+	}
+	return errs[0]
+}
+
+// latencyTracker keeps time to response headers of recent successful requests
+type latencyTracker struct {
+	mu          sync.Mutex
+	samples     []time.Duration
+	next        int
+	sinceRecalc int
+	percentile  float64
+	cached      time.Duration
+}
+
+func (tracker *latencyTracker) Add(latency time.Duration) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if len(tracker.samples) < latencyWindow {
+		tracker.samples = append(tracker.samples, latency)
+	} else {
+		tracker.samples[tracker.next] = latency
+		tracker.next = (tracker.next + 1) % latencyWindow
+	}
+	tracker.sinceRecalc++
+}
+
+// Percentile returns false until at least minSamples collected
+func (tracker *latencyTracker) Percentile(percentile float64, minSamples int) (time.Duration, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if len(tracker.samples) == 0 || len(tracker.samples) < minSamples {
+		return 0, false
+	}
+	if tracker.cached == 0 || tracker.percentile != percentile || tracker.sinceRecalc >= latencyRecalcEvery {
+		sorted := append([]time.Duration{}, tracker.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		idx := int(float64(len(sorted)-1) * percentile / 100)
+		tracker.cached, tracker.percentile, tracker.sinceRecalc = sorted[idx], percentile, 0
+	}
+	return tracker.cached, true
+}
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func MiddlewareCount(counter *uint64) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddUint64(counter, 1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func MiddlewareStatus(status int) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+	}
+}
+
+func MiddlewareDelay(delay time.Duration) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func NewMirrorClient(servers ...*httptest.Server) *BackupClient {
+	prefixes := make([]string, 0, len(servers))
+	for _, server := range servers {
+		prefixes = append(prefixes, server.URL+"/backup/")
+	}
+	return &BackupClient{
+		Mirrors: NewBackupMirrors(prefixes),
+		Client:  servers[0].Client(),
+	}
+}
+
+func assertBackupBody(t *testing.T, body io.ReadCloser, err error, desc string) {
+	if assert.NoError(t, err, desc) {
+		data, _ := ioutil.ReadAll(body)
+		assert.Equal(t, ExpectedFileContent, string(data), "body read when %s", desc)
+		body.Close()
+	}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	desc := "first mirror fails"
+	var brokenCount uint64
+	Broken := NewMockHTTPServerBackup(t, desc, []Middleware{MiddlewareCount(&brokenCount), MiddlewareStatus(http.StatusBadGateway)})
+	defer Broken.Close()
+	Good := NewMockHTTPServerBackup(t, desc, nil)
+	defer Good.Close()
+
+	backup := NewMirrorClient(Broken, Good)
+	backup.Health = MirrorHealth{FailThreshold: 1, Cooldown: time.Minute}
+
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	assertBackupBody(t, body, err, desc)
+	assert.False(t, backup.Mirrors[0].Healthy(time.Now()), "failed mirror marked down")
+
+	body, err = backup.RequestBackupBody(FileIDSuccess)
+	assertBackupBody(t, body, err, "second request")
+	body, err = backup.RequestBackupBody(FileIDSuccess)
+	assertBackupBody(t, body, err, "third request")
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&brokenCount), "mirror being down not requested while healthy one works")
+}
+
+func TestMirrorPermanentDoesNotHurtHealth(t *testing.T) {
+	mirror := &BackupMirror{}
+	health := MirrorHealth{FailThreshold: 1, Cooldown: time.Minute}
+	mirror.report(health, &RequestError{Class: ErrorClassPermanent})
+	assert.True(t, mirror.Healthy(time.Now()), "404 is not mirror failure")
+	mirror.report(health, ErrHedgeLost)
+	assert.True(t, mirror.Healthy(time.Now()), "lost hedge is not mirror failure")
+	mirror.report(health, &RequestError{Class: ErrorClassServer})
+	assert.False(t, mirror.Healthy(time.Now()), "server error is mirror failure")
+	assert.True(t, mirror.Healthy(time.Now().Add(2*time.Minute)), "mirror back after cooldown")
+	mirror.report(health, nil)
+	assert.True(t, mirror.Healthy(time.Now()), "success makes mirror healthy")
+}
+
+func TestMirrorAllFailed(t *testing.T) {
+	desc := "no backup anywhere"
+	NotFound := NewMockHTTPServerBackup(t, desc, []Middleware{MiddlewareStatus(http.StatusNotFound)})
+	defer NotFound.Close()
+	Broken := NewMockHTTPServerBackup(t, desc, []Middleware{MiddlewareStatus(http.StatusInternalServerError)})
+	defer Broken.Close()
+
+	body, err := NewMirrorClient(NotFound, NotFound).RequestBackupBody(FileIDSuccess)
+	assert.Nil(t, body, "body is nil when error")
+	assert.False(t, IsRetryable(err), "missing on every mirror is permanent")
+
+	_, err = NewMirrorClient(NotFound, Broken).RequestBackupBody(FileIDSuccess)
+	assert.True(t, IsRetryable(err), "retryable if some mirror failed temporarily")
+}
+
+func TestMirrorHedge(t *testing.T) {
+	desc := "first mirror slow"
+	Slow := NewMockHTTPServerBackup(t, desc, []Middleware{MiddlewareDelay(time.Second)})
+	defer Slow.Close()
+	Fast := NewMockHTTPServerBackup(t, desc, nil)
+	defer Fast.Close()
+
+	backup := NewMirrorClient(Slow, Fast)
+	backup.HedgePercentile = 90
+	backup.HedgeMinSamples = 10
+	for i := 0; i < 10; i++ {
+		backup.latency.Add(10 * time.Millisecond)
+	}
+
+	started := time.Now()
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	assertBackupBody(t, body, err, desc)
+	assert.Less(t, int64(time.Since(started)), int64(500*time.Millisecond), "hedged request won")
+}
+
+func TestLatencyTrackerPercentile(t *testing.T) {
+	tracker := latencyTracker{}
+	_, ok := tracker.Percentile(50, 1)
+	assert.False(t, ok, "no samples")
+	for i := 1; i <= 100; i++ {
+		tracker.Add(time.Duration(i) * time.Millisecond)
+	}
+	_, ok = tracker.Percentile(50, 200)
+	assert.False(t, ok, "not enough samples")
+	p, ok := tracker.Percentile(90, 100)
+	assert.True(t, ok, "enough samples")
+	assert.Equal(t, 90*time.Millisecond, p, "90th percentile")
+}
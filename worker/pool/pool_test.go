@@ -179,3 +179,41 @@ func TestParallelTaskProcessing(t *testing.T) {
 	assert.Equal(t, jobCount, gotCount, "all tasks processed")
 	assert.WithinDuration(t, finish, start, 10*jobDelay, "parallel execution")
 }
+
+// TestBurstOfDueRetries feeds pool the way main loop does: retries due at
+// once outnumber workers, results are read while they are sent
+func TestBurstOfDueRetries(t *testing.T) {
+	wp := WorkerPool{MaxParallel: 4}
+	wp.Go(AlwaysOK)
+	var delayed worker.DelayQueue
+	var ready worker.SendQueue
+	notBefore := time.Now().Add(10 * time.Millisecond)
+	for i := 1; i <= 50; i++ {
+		delayed.Push(worker.WorkerTask{Line: uint64(i), Id: fmt.Sprint(i), NotBefore: notBefore})
+	}
+	timeout := time.After(5 * time.Second)
+	done, stopped := 0, false
+	for open := true; open; {
+		select {
+		case fired := <-delayed.C():
+			for _, task := range delayed.Due(fired) {
+				ready.Push(task)
+			}
+		case ready.C(wp.InputChannel) <- ready.Next():
+			ready.Sent()
+		case _, ok := <-wp.OutputChannel:
+			if !ok {
+				open = false
+				break
+			}
+			done++
+			if done == 50 && !stopped {
+				stopped = true
+				wp.StopAsync()
+			}
+		case <-timeout:
+			t.Fatalf("pool stalled after %d of 50 tasks, %d not sent", done, ready.Len())
+		}
+	}
+	assert.Equal(t, 50, done, "every due retry processed")
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const (
+	RedirectFollow   = "follow"
+	RedirectSameHost = "same_host"
+	RedirectDeny     = "deny"
+
+	defaultRedirectMaxHops = 10
+)
+
+// DefaultSensitiveHeaders never leave original host on redirect
+var DefaultSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Host1",
+	"X-Amz-Security-Token",
+}
+
+// RedirectPolicy decides whether http.Client follows redirect. Denied redirect
+// is not an transport error: 3xx response is returned to caller as is, so it is
+// reported like any other unexpected status
+type RedirectPolicy struct {
+	Mode             string
+	MaxHops          int
+	SensitiveHeaders []string
+}
+
+func (policy RedirectPolicy) Validate() error {
+	switch policy.Mode {
+	case RedirectFollow, RedirectSameHost, RedirectDeny:
+		return nil
+	}
+	return fmt.Errorf("unknown redirect mode %q, expect one of %s, %s, %s",
+		policy.Mode, RedirectFollow, RedirectSameHost, RedirectDeny)
+}
+
+func (policy RedirectPolicy) maxHops() int {
+	if policy.MaxHops > 0 {
+		return policy.MaxHops
+	}
+	return defaultRedirectMaxHops
+}
+
+func (policy RedirectPolicy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if chain, ok := req.Context().Value(redirectChainKey{}).(*RedirectChain); ok {
+		chain.Urls = append(chain.Urls, req.URL.String())
+	}
+	switch policy.Mode {
+	case RedirectDeny:
+		return http.ErrUseLastResponse
+	case RedirectSameHost:
+		if req.URL.Host != via[0].URL.Host {
+			return http.ErrUseLastResponse
+		}
+	}
+	if len(via) > policy.maxHops() {
+		return http.ErrUseLastResponse
+	}
+	// 301, 302 and 303 turn PUT into GET without body, its 200 would pass
+	// for restored object
+	if req.Method != via[0].Method || (via[0].ContentLength != 0 && via[0].GetBody == nil) {
+		return http.ErrUseLastResponse
+	}
+	if req.URL.Host != via[0].URL.Host {
+		for _, name := range policy.SensitiveHeaders {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// Client returns copy of base client with policy applied
+func (policy RedirectPolicy) Client(base *http.Client) *http.Client {
+	client := *base
+	client.CheckRedirect = policy.CheckRedirect
+	return &client
+}
+
+type redirectChainKey struct{}
+
+// RedirectChain collects urls client was redirected to during single request
+type RedirectChain struct {
+	Urls []string
+}
+
+func WithRedirectChain(req *http.Request) (*http.Request, *RedirectChain) {
+	chain := &RedirectChain{}
+	return req.WithContext(context.WithValue(req.Context(), redirectChainKey{}, chain)), chain
+}
@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NewMockHTTPServerRedirect redirects every request to target keeping path
+func NewMockHTTPServerRedirect(target string, code int) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target+r.URL.Path, code)
+	}))
+}
+
+func TestRedirectPolicyValidate(t *testing.T) {
+	for _, mode := range []string{RedirectFollow, RedirectSameHost, RedirectDeny} {
+		assert.NoError(t, RedirectPolicy{Mode: mode}.Validate(), "mode %s valid", mode)
+	}
+	assert.Error(t, RedirectPolicy{Mode: "sometimes"}.Validate(), "unknown mode")
+	assert.Error(t, RedirectPolicy{}.Validate(), "empty mode")
+}
+
+func TestBackupRedirectFollowStripsSensitiveHeaders(t *testing.T) {
+	// net/http drops Authorization on redirect to other host by itself, custom
+	// headers are copied unless policy strips them
+	headers := http.Header{
+		"X-Amz-Security-Token": {"session-secret"},
+		"X-Request-Tag":        {"restore-42"},
+	}
+	tests := []struct {
+		Desc      string
+		Sensitive []string
+		WantToken string
+	}{
+		{Desc: "sensitive header stripped by policy", Sensitive: DefaultSensitiveHeaders, WantToken: ""},
+		{Desc: "header kept without policy", Sensitive: nil, WantToken: "session-secret"},
+	}
+	for _, test := range tests {
+		mw := []Middleware{
+			MiddlewareEnsureHeader(t, "X-Amz-Security-Token", test.WantToken, test.Desc),
+			MiddlewareEnsureHeader(t, "X-Request-Tag", "restore-42", test.Desc),
+		}
+		BackupServ := NewMockHTTPServerBackup(t, test.Desc, mw)
+		RedirectServ := NewMockHTTPServerRedirect(BackupServ.URL, http.StatusFound)
+
+		policy := RedirectPolicy{Mode: RedirectFollow, SensitiveHeaders: test.Sensitive}
+		restorer := BackupClient{
+			BackupUrlPrefix: fmt.Sprintf("%s/%s/", RedirectServ.URL, "backup"),
+			Auth:            headerAuth{headers},
+			Client:          policy.Client(RedirectServ.Client()),
+		}
+
+		body, err := restorer.RequestBackupBody(FileIDSuccess)
+		if assert.NoError(t, err, "redirect followed: %s", test.Desc) {
+			data, _ := ioutil.ReadAll(body)
+			body.Close()
+			assert.Equal(t, ExpectedFileContent, string(data), "body from redirect target: %s", test.Desc)
+		}
+		RedirectServ.Close()
+		BackupServ.Close()
+	}
+}
+
+func TestBackupRedirectSameHostDenied(t *testing.T) {
+	desc := "redirect to other host denied"
+	BackupServ := NewMockHTTPServerBackup(t, desc, nil)
+	defer BackupServ.Close()
+	RedirectServ := NewMockHTTPServerRedirect(BackupServ.URL, http.StatusFound)
+	defer RedirectServ.Close()
+
+	policy := RedirectPolicy{Mode: RedirectSameHost}
+	restorer := BackupClient{
+		BackupUrlPrefix: fmt.Sprintf("%s/%s/", RedirectServ.URL, "backup"),
+		Client:          policy.Client(RedirectServ.Client()),
+	}
+
+	body, err := restorer.RequestBackupBody(FileIDSuccess)
+	assert.Nil(t, body, "body is nil when error")
+	var reqErr *RequestError
+	if assert.ErrorAs(t, err, &reqErr, "typed error returned") {
+		assert.Equal(t, http.StatusFound, reqErr.StatusCode, "redirect response returned")
+		assert.False(t, reqErr.Retryable(), "denied redirect not retryable")
+		assert.Equal(t, []string{BackupServ.URL + "/backup/" + FileIDSuccess}, reqErr.Redirects, "redirect chain reported")
+		assert.Contains(t, reqErr.Error(), "redirects: ", "redirect chain logged")
+	}
+}
+
+func TestBackupRedirectMaxHops(t *testing.T) {
+	desc := "redirect loop"
+	var LoopServ *httptest.Server
+	LoopServ = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, LoopServ.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer LoopServ.Close()
+
+	policy := RedirectPolicy{Mode: RedirectFollow, MaxHops: 2}
+	restorer := BackupClient{
+		BackupUrlPrefix: fmt.Sprintf("%s/%s/", LoopServ.URL, "backup"),
+		Client:          policy.Client(LoopServ.Client()),
+	}
+
+	_, err := restorer.RequestBackupBody(FileIDSuccess)
+	var reqErr *RequestError
+	if assert.ErrorAs(t, err, &reqErr, "typed error returned when %s", desc) {
+		assert.Equal(t, http.StatusFound, reqErr.StatusCode, "last redirect response returned")
+		assert.Len(t, reqErr.Redirects, 3, "two hops followed, third refused")
+	}
+}
+
+func TestAmazonRedirectDenied(t *testing.T) {
+	desc := "upload redirect denied"
+	requestCount := 0
+	mw := []Middleware{
+		func(next http.HandlerFunc) http.HandlerFunc {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestCount++
+				next.ServeHTTP(w, r)
+			})
+		},
+	}
+	AmazonServ := NewMockHTTPServerAmazon(t, desc, mw)
+	defer AmazonServ.Close()
+	RedirectServ := NewMockHTTPServerRedirect(AmazonServ.URL, http.StatusMovedPermanently)
+	defer RedirectServ.Close()
+
+	policy := RedirectPolicy{Mode: RedirectDeny}
+	amazon := AmazonRestorer{
+		UrlPrefix: fmt.Sprintf("%s/%s", RedirectServ.URL, AmazonPrefix),
+		Client:    policy.Client(RedirectServ.Client()),
+		Bucket:    ValidBucketID,
+	}
+
+	body := ioutil.NopCloser(strings.NewReader(ExpectedFileContent))
+	err := amazon.PutObjectFromReader(FileIDSuccess, body)
+	assert.Error(t, err, "redirect is an error")
+	assert.Equal(t, 0, requestCount, "upload not sent to redirect target")
+}
+
+func TestAmazonRedirectFollowKeepsUpload(t *testing.T) {
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		var methods []string
+		var bodies []string
+		TargetServ := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			methods = append(methods, r.Method)
+			bodies = append(bodies, string(body))
+		}))
+		RedirectServ := NewMockHTTPServerRedirect(TargetServ.URL, code)
+
+		policy := RedirectPolicy{Mode: RedirectFollow}
+		amazon := AmazonRestorer{
+			UrlPrefix: fmt.Sprintf("%s/%s", RedirectServ.URL, AmazonPrefix),
+			Client:    policy.Client(RedirectServ.Client()),
+			Bucket:    ValidBucketID,
+		}
+		err := amazon.PutObjectFromReader(FileIDSuccess, ioutil.NopCloser(strings.NewReader(ExpectedFileContent)))
+		if err == nil {
+			assert.Equal(t, []string{http.MethodPut}, methods, "redirect %d: upload followed as PUT", code)
+			assert.Equal(t, []string{ExpectedFileContent}, bodies, "redirect %d: full body uploaded", code)
+		} else {
+			assert.Empty(t, methods, "redirect %d: nothing sent to target of failed upload", code)
+		}
+		RedirectServ.Close()
+		TargetServ.Close()
+	}
+}
+
+// headerAuth sets headers before request is sent, so http.Client copies
+// them to redirected requests
+type headerAuth struct {
+	Header http.Header
+}
+
+func (h headerAuth) Authenticate(req *http.Request) error {
+	for name, values := range h.Header {
+		req.Header[name] = values
+	}
+	return nil
+}
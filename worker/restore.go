@@ -1,83 +1,266 @@
 package worker
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type BackupClient struct {
 	BackupUrlPrefix string
+	UrlTemplate     *KeyTemplate    // used instead of BackupUrlPrefix when set
+	Mirrors         []*BackupMirror // BackupUrlPrefix or UrlTemplate is used when empty
+	Health          MirrorHealth
+	HedgePercentile float64 // hedge request to next mirror after this latency percentile, 0 disables
+	HedgeMinSamples int
+	Auth            Authenticator // nil for anonymous requests
 	Client          *http.Client
 	Timeout         time.Duration
+	Limits          TransferLimits
+	latency         latencyTracker
 }
 
-func (instance *BackupClient) BackupUrl(file_id string) string {
-	Url := instance.BackupUrlPrefix + file_id
-	return Url
+// EscapeKey percent-encodes file id for url path. Slashes are kept, so keys
+// with directories map to backup paths as before
+func EscapeKey(file_id string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(file_id); i++ {
+		c := file_id[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+func (instance *BackupClient) BackupUrl(file_id string) (string, error) {
+	return instance.mirrors()[0].Url(file_id)
+}
+
+func (instance *BackupClient) mirrors() []*BackupMirror {
+	if len(instance.Mirrors) == 0 {
+		return []*BackupMirror{{UrlPrefix: instance.BackupUrlPrefix, Template: instance.UrlTemplate}}
+	}
+	return orderMirrors(instance.Mirrors, time.Now())
+}
+
+func (instance *BackupClient) hedgeDelay() (time.Duration, bool) {
+	if instance.HedgePercentile <= 0 {
+		return 0, false
+	}
+	return instance.latency.Percentile(instance.HedgePercentile, instance.HedgeMinSamples)
 }
 
+type mirrorResult struct {
+	body io.ReadCloser
+	err  error
+}
+
+// RequestBackupBody tries mirrors in order of health until one succeeds. When
+// hedging is on and first mirror is slower than usual, request is duplicated
+// to the next mirror and the first response wins
 func (instance *BackupClient) RequestBackupBody(file_id string) (io.ReadCloser, error) {
-	Url := instance.BackupUrl(file_id)
+	mirrors := instance.mirrors()
+	if len(mirrors) == 1 {
+		return instance.requestMirror(mirrors[0], file_id, nil)
+	}
+
+	results := make(chan mirrorResult, len(mirrors))
+	abort := make(chan struct{})
+	launched, pending := 0, 0
+	launch := func() {
+		mirror := mirrors[launched]
+		launched++
+		pending++
+		go func() {
+			body, err := instance.requestMirror(mirror, file_id, abort)
+			results <- mirrorResult{body: body, err: err}
+		}()
+	}
+
+	launch()
+	var hedge <-chan time.Time
+	if delay, ok := instance.hedgeDelay(); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	errs := make([]error, 0, len(mirrors))
+	for pending > 0 {
+		select {
+		case <-hedge:
+			hedge = nil
+			if launched < len(mirrors) {
+				launch()
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				close(abort)
+				go func(pending int) {
+					// late winners of lost race
+					for ; pending > 0; pending-- {
+						if late := <-results; late.err == nil {
+							late.body.Close()
+						}
+					}
+				}(pending)
+				return res.body, nil
+			}
+			errs = append(errs, res.err)
+			if launched < len(mirrors) {
+				launch()
+			}
+		}
+	}
+	return nil, pickMirrorError(errs)
+}
 
-	req, err := http.NewRequest("GET", Url, nil)
+// requestMirror retries once with fresh token when server rejects cached one
+func (instance *BackupClient) requestMirror(mirror *BackupMirror, file_id string, abort <-chan struct{}) (io.ReadCloser, error) {
+	body, req, err := instance.requestMirrorOnce(mirror, file_id, abort)
+	invalidator, ok := instance.Auth.(TokenInvalidator)
+	var reqErr *RequestError
+	if ok && req != nil && errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusUnauthorized {
+		invalidator.Invalidate(req)
+		body, _, err = instance.requestMirrorOnce(mirror, file_id, abort)
+	}
+	return body, err
+}
+
+// requestMirrorOnce returns request it sent, nil when error happened before
+func (instance *BackupClient) requestMirrorOnce(mirror *BackupMirror, file_id string, abort <-chan struct{}) (io.ReadCloser, *http.Request, error) {
+	Url, err := mirror.Url(file_id)
 	if err != nil {
-		return nil, err
+		return nil, nil, NewRequestBuildError(PhaseBackup, mirror.UrlPrefix, err)
 	}
 
-	if instance.Timeout > 0 {
-		ctx, _ := context.WithTimeout(context.Background(), instance.Timeout)
-		req = req.WithContext(ctx)
+	// transfer lives until caller closes returned body
+	transfer := newTransfer(instance.Timeout, instance.Limits)
+	req, err := http.NewRequestWithContext(transfer.ctx, "GET", Url, nil)
+	if err != nil {
+		transfer.stop()
+		return nil, nil, NewRequestBuildError(PhaseBackup, Url, err)
+	}
+	if instance.Auth != nil {
+		if err := instance.Auth.Authenticate(req); err != nil {
+			transfer.stop()
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				reqErr = NewRequestBuildError(PhaseAuth, Url, err)
+			}
+			return nil, nil, reqErr
+		}
 	}
 
-	// FIXME: check for redirects
+	if abort != nil {
+		headersDone := make(chan struct{})
+		defer close(headersDone)
+		go func() {
+			select {
+			case <-abort:
+				transfer.cancel(ErrHedgeLost)
+			case <-headersDone:
+			}
+		}()
+	}
+
+	req, redirects := WithRedirectChain(req)
+	started := time.Now()
+	transfer.startFirstByteTimer()
 	resp, err := instance.Client.Do(req)
+	transfer.stopFirstByteTimer()
 	if err != nil {
-		return nil, err
+		err = transfer.explain(redactError(err))
+		transfer.stop()
+		reqErr := NewTransportError(PhaseBackup, Url, err).WithRedirects(redirects)
+		mirror.report(instance.Health, reqErr)
+		return nil, req, reqErr
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("[%s] status code %d", Url, resp.StatusCode)
+		defer transfer.stop()
+		reqErr := NewResponseError(PhaseBackup, Url, resp).WithRedirects(redirects)
+		mirror.report(instance.Health, reqErr)
+		return nil, req, reqErr
 	}
+	instance.latency.Add(time.Since(started))
+	mirror.report(instance.Health, nil)
 
-	return resp.Body, nil
+	transfer.startWatchdog()
+	body := &watchedReader{
+		ReadCloser:  resp.Body,
+		transfer:    transfer,
+		onEOF:       transfer.stopWatchdog,
+		stopOnClose: true,
+	}
+	return body, req, nil
 }
 
 type AmazonRestorer struct {
-	UrlPrefix string
-	Client    *http.Client
-	Bucket    string
-	Timeout   time.Duration
+	UrlPrefix   string
+	KeyTemplate *KeyTemplate // destination key, file id when nil
+	Client      *http.Client
+	Bucket      string
+	Timeout     time.Duration
+	Limits      TransferLimits // body limits apply to upload, first byte timer starts when upload done
 }
 
-func (instance *AmazonRestorer) UploadUrl(file_id string) string {
-	Url := fmt.Sprintf("%s/%s", instance.UrlPrefix, file_id)
-	return Url
+// DestinationKey is where file id is restored to
+func (instance *AmazonRestorer) DestinationKey(file_id string) (string, error) {
+	if instance.KeyTemplate == nil {
+		return file_id, nil
+	}
+	return instance.KeyTemplate.Expand(file_id, nil)
 }
 
-func (instance *AmazonRestorer) PutObjectFromReader(file_id string, body io.ReadCloser) error {
-	Url := instance.UploadUrl(file_id)
+// UploadUrl joins UrlPrefix and escaped key with single slash, whether prefix
+// ends with slash or not
+func (instance *AmazonRestorer) UploadUrl(file_id string) (string, error) {
+	key, err := instance.DestinationKey(file_id)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(instance.UrlPrefix, "/") + "/" + EscapeKey(key), nil
+}
 
-	req, err := http.NewRequest("PUT", Url, body)
+func (instance *AmazonRestorer) PutObjectFromReader(file_id string, body io.ReadCloser) error {
+	Url, err := instance.UploadUrl(file_id)
 	if err != nil {
-		return err
+		body.Close()
+		return NewRequestBuildError(PhaseRestore, instance.UrlPrefix, err)
 	}
-	req.Header.Set("Host1", instance.Bucket)
 
-	if instance.Timeout > 0 {
-		ctx, _ := context.WithTimeout(context.Background(), instance.Timeout)
-		req = req.WithContext(ctx)
+	transfer := newTransfer(instance.Timeout, instance.Limits)
+	defer transfer.stop()
+	upload := &watchedReader{ReadCloser: body, transfer: transfer, onEOF: transfer.uploadDone}
+	req, err := http.NewRequestWithContext(transfer.ctx, "PUT", Url, upload)
+	if err != nil {
+		body.Close()
+		return NewRequestBuildError(PhaseRestore, Url, err)
 	}
+	req.Header.Set("Host1", instance.Bucket)
 
-	// FIXME: check for redirects
+	req, redirects := WithRedirectChain(req)
+	transfer.startWatchdog()
 	resp, err := instance.Client.Do(req)
+	transfer.stopFirstByteTimer()
 	if err != nil {
-		return err
+		return NewTransportError(PhaseRestore, Url, transfer.explain(err)).WithRedirects(redirects)
 	}
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("[%s] status code %d", Url, resp.StatusCode)
+		return NewResponseError(PhaseRestore, Url, resp).WithRedirects(redirects)
 	}
+	// drain body so connection goes back to idle pool
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
 
 	return nil
 }
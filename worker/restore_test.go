@@ -316,3 +316,103 @@ func TestRequestAmazonTimeout(t *testing.T) {
 	err := amazon.PutObjectFromReader(FileIDSuccess, body)
 	assert.Error(t, err, "not 200 OK")
 }
+
+func TestRequestBackupBody_NotFound_Permanent(t *testing.T) {
+	desc := "backup missing"
+	BackupServ := NewMockHTTPServerBackup(t, desc, nil)
+	defer BackupServ.Close()
+
+	restorer := BackupClient{
+		BackupUrlPrefix: fmt.Sprintf("%s/%s/", BackupServ.URL, "backup"),
+		Client:          BackupServ.Client(),
+	}
+
+	body, err := restorer.RequestBackupBody("no-such-file")
+	assert.Nil(t, body, "body is nil when error")
+	var reqErr *RequestError
+	if assert.ErrorAs(t, err, &reqErr, "typed error returned") {
+		assert.Equal(t, http.StatusNotFound, reqErr.StatusCode, "status code kept")
+		assert.Equal(t, PhaseBackup, reqErr.Phase, "phase is backup")
+		assert.False(t, reqErr.Retryable(), "404 is not retryable")
+	}
+}
+
+func TestRequestAmazonSlowDown(t *testing.T) {
+	desc := "server asks to slow down"
+	mw := []Middleware{
+		func(next http.HandlerFunc) http.HandlerFunc {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, "<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>")
+			})
+		},
+	}
+	AmazonServ := NewMockHTTPServerAmazon(t, desc, mw)
+	defer AmazonServ.Close()
+
+	amazon := AmazonRestorer{
+		UrlPrefix: fmt.Sprintf("%s/%s", AmazonServ.URL, AmazonPrefix),
+		Client:    AmazonServ.Client(),
+		Bucket:    ValidBucketID,
+	}
+
+	body := ioutil.NopCloser(strings.NewReader(ExpectedFileContent))
+	err := amazon.PutObjectFromReader(FileIDSuccess, body)
+	var reqErr *RequestError
+	if assert.ErrorAs(t, err, &reqErr, "typed error returned") {
+		assert.Equal(t, PhaseRestore, reqErr.Phase, "phase is restore")
+		assert.Equal(t, "SlowDown", reqErr.Code, "S3 error code parsed")
+		assert.Equal(t, ErrorClassThrottled, reqErr.Class, "throttled")
+		assert.Equal(t, 7*time.Second, reqErr.RetryAfter, "Retry-After honored")
+		assert.True(t, reqErr.Retryable(), "throttled is retryable")
+	}
+}
+
+func TestRequestAmazonRequestSendError_Retryable(t *testing.T) {
+	desc := "request transport error is retryable"
+	AmazonServ := NewMockHTTPServerAmazon(t, desc, nil)
+
+	amazon := AmazonRestorer{
+		UrlPrefix: fmt.Sprintf("%s/%s", AmazonServ.URL, AmazonPrefix),
+		Client:    AmazonServ.Client(),
+		Bucket:    ValidBucketID,
+	}
+
+	AmazonServ.Close()
+	body := ioutil.NopCloser(strings.NewReader(ExpectedFileContent))
+	err := amazon.PutObjectFromReader(FileIDSuccess, body)
+	assert.Equal(t, ErrorClassNetwork, ClassOf(err), "transport error class")
+	assert.True(t, IsRetryable(err), "transport error retryable")
+}
+
+func TestEscapeKey(t *testing.T) {
+	assert.Equal(t, "dir/file-1_2.txt~", EscapeKey("dir/file-1_2.txt~"), "unreserved and slash kept")
+	assert.Equal(t, "my%20key%3Fv%3D1%23frag%2B%25", EscapeKey("my key?v=1#frag+%"), "reserved escaped")
+	assert.Equal(t, "%D1%84%D0%B0%D0%B9%D0%BB", EscapeKey("файл"), "utf-8 bytes escaped")
+
+	backup := &BackupClient{BackupUrlPrefix: "https://backup/files/"}
+	Url, err := backup.BackupUrl("a b/c")
+	assert.NoError(t, err, "backup url")
+	assert.Equal(t, "https://backup/files/a%20b/c", Url, "backup url")
+	amazon := &AmazonRestorer{UrlPrefix: "https://s3/bucket"}
+	Url, err = amazon.UploadUrl("a b")
+	assert.NoError(t, err, "upload url")
+	assert.Equal(t, "https://s3/bucket/a%20b", Url, "upload url")
+}
+
+func TestRequestBackupBodyEscapedKey(t *testing.T) {
+	var gotPath string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		fmt.Fprint(w, ExpectedFileContent)
+	}))
+	defer server.Close()
+
+	backup := &BackupClient{BackupUrlPrefix: server.URL + "/backup/", Client: server.Client()}
+	body, err := backup.RequestBackupBody("key with spaces?#")
+	if assert.NoError(t, err, "key with spaces requested") {
+		body.Close()
+	}
+	assert.Equal(t, "/backup/key with spaces?#", gotPath, "server decodes key back")
+}
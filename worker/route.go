@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mxpaul/unfuckup_s3/generator"
+)
+
+var ErrNoRoute = errors.New("no route for file id")
+
+// Route sends file ids to named destination. Every rule set must match:
+// Prefix, Pattern and Ids from lookup file
+type Route struct {
+	Destination string
+	Prefix      string
+	Pattern     *regexp.Regexp
+	Ids         map[string]struct{} // nil does not filter
+}
+
+func (route *Route) Match(file_id string) bool {
+	switch {
+	case !strings.HasPrefix(file_id, route.Prefix):
+		return false
+	case route.Pattern != nil && !route.Pattern.MatchString(file_id):
+		return false
+	case route.Ids != nil:
+		_, ok := route.Ids[file_id]
+		return ok
+	}
+	return true
+}
+
+// Router picks destination by first matching route, ids no route matches go
+// to Default. Empty Default makes unmatched id an error
+type Router struct {
+	Routes  []Route
+	Default string
+}
+
+func (router *Router) Route(file_id string) (string, error) {
+	for i := range router.Routes {
+		if router.Routes[i].Match(file_id) {
+			return router.Routes[i].Destination, nil
+		}
+	}
+	if router.Default == "" {
+		return "", fmt.Errorf("%w %s", ErrNoRoute, file_id)
+	}
+	return router.Default, nil
+}
+
+// Destinations lists destinations ids may be routed to, Default first
+func (router *Router) Destinations() []string {
+	names := make([]string, 0, len(router.Routes)+1)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(router.Default)
+	for _, route := range router.Routes {
+		add(route.Destination)
+	}
+	return names
+}
+
+// LoadRouteIds reads lookup file the way lines input is read, so ids match
+// ones generator gives: "quoted ids" are unescaped, empty lines are skipped
+func LoadRouteIds(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ids := make(map[string]struct{})
+	reader := generator.NewLineReader(file)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("route ids %s: %w", path, err)
+		}
+		if record.Id != "" {
+			ids[record.Id] = struct{}{}
+		}
+	}
+}
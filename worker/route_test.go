@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	lookup := filepath.Join(t.TempDir(), "archive.txt")
+	long := "old/" + strings.Repeat("x", 100000)
+	require.NoError(t, ioutil.WriteFile(lookup, []byte("old/1\n\nold/2\r\n\"old 4\"\n"+long+"\n"), 0644), "lookup file")
+	ids, err := LoadRouteIds(lookup)
+	require.NoError(t, err, "load lookup file")
+	assert.Len(t, ids, 4, "empty lines skipped")
+	assert.Contains(t, ids, "old 4", "quoted id unescaped")
+	assert.Contains(t, ids, long, "line longer than scanner buffer")
+
+	router := &Router{
+		Routes: []Route{
+			{Destination: "archive", Ids: ids},
+			{Destination: "eu", Prefix: "eu/"},
+			{Destination: "us", Pattern: regexp.MustCompile(`^us-[0-9]+$`)},
+			{Destination: "eu", Prefix: "old/", Pattern: regexp.MustCompile(`/[0-9]$`)},
+		},
+		Default: "restore",
+	}
+	tests := map[string]string{
+		"old/1":  "archive",
+		"old/2":  "archive",
+		"old/3":  "eu",
+		"old/33": "restore",
+		"eu/old": "eu",
+		"us-42":  "us",
+		"us-x":   "restore",
+	}
+	for id, want := range tests {
+		got, err := router.Route(id)
+		assert.NoError(t, err, "route %s", id)
+		assert.Equal(t, want, got, "route %s", id)
+	}
+	assert.Equal(t, []string{"restore", "archive", "eu", "us"}, router.Destinations(), "destinations in order")
+
+	router.Default = ""
+	_, err = router.Route("us-x")
+	assert.ErrorIs(t, err, ErrNoRoute, "unmatched without default")
+
+	_, err = LoadRouteIds(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err, "missing lookup file")
+	require.NoError(t, ioutil.WriteFile(lookup, []byte("old/1\n\"broken\n"), 0644), "broken lookup file")
+	_, err = LoadRouteIds(lookup)
+	assert.Error(t, err, "broken quoting")
+}
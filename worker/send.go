@@ -0,0 +1,45 @@
+package worker
+
+// SendQueue holds tasks ready for workers, so loop feeding pool sends them in
+// select next to reading results and never blocks on busy workers. Not safe
+// for concurrent use
+type SendQueue struct {
+	tasks []WorkerTask
+}
+
+func (queue *SendQueue) Push(task WorkerTask) {
+	queue.tasks = append(queue.tasks, task)
+}
+
+func (queue *SendQueue) Len() int {
+	return len(queue.tasks)
+}
+
+// C returns in when tasks wait, nil channel otherwise, so select does not
+// send from empty queue
+func (queue *SendQueue) C(in chan<- WorkerTask) chan<- WorkerTask {
+	if len(queue.tasks) == 0 {
+		return nil
+	}
+	return in
+}
+
+// Next is task to send, zero task when queue is empty
+func (queue *SendQueue) Next() WorkerTask {
+	if len(queue.tasks) == 0 {
+		return WorkerTask{}
+	}
+	return queue.tasks[0]
+}
+
+// Sent removes task Next returned after it was sent
+func (queue *SendQueue) Sent() {
+	queue.tasks = queue.tasks[1:]
+}
+
+// Drain removes and returns tasks not sent yet
+func (queue *SendQueue) Drain() []WorkerTask {
+	tasks := queue.tasks
+	queue.tasks = nil
+	return tasks
+}
@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStalled          = errors.New("transfer stalled")
+	ErrIdleRead         = errors.New("no data read for too long")
+	ErrFirstByteTimeout = errors.New("no response headers for too long")
+
+	watchdogMaxTick = time.Second
+)
+
+// TransferLimits protects against slow peers. Total request timeout is hardly
+// useful when files range from kilobytes to gigabytes, so each phase has own
+// limit. Zero value disables corresponding check
+type TransferLimits struct {
+	FirstByteTimeout  time.Duration // from request sent to response headers
+	IdleReadTimeout   time.Duration // max pause between body reads returning data
+	MinBytesPerSecond uint64        // abort if throughput stays lower ...
+	StallPeriod       time.Duration // ... for this long
+}
+
+func (limits TransferLimits) watchBody() bool {
+	return limits.IdleReadTimeout > 0 || (limits.MinBytesPerSecond > 0 && limits.StallPeriod > 0)
+}
+
+func (limits TransferLimits) tick() time.Duration {
+	tick := watchdogMaxTick
+	for _, limit := range []time.Duration{limits.IdleReadTimeout / 4, limits.StallPeriod / 4} {
+		if limit > 0 && limit < tick {
+			tick = limit
+		}
+	}
+	return tick
+}
+
+// transfer carries context of single request and its watchdog
+type transfer struct {
+	ctx          context.Context
+	cancel       context.CancelCauseFunc
+	stopTimeout  context.CancelFunc
+	limits       TransferLimits
+	mu           sync.Mutex
+	total        uint64
+	lastRead     time.Time
+	samples      []transferSample
+	firstByte    *time.Timer
+	watchdogStop chan struct{}
+	watchdogOnce sync.Once
+	stopOnce     sync.Once
+}
+
+type transferSample struct {
+	at    time.Time
+	total uint64
+}
+
+func newTransfer(timeout time.Duration, limits TransferLimits) *transfer {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	t := &transfer{cancel: cancel, limits: limits, stopTimeout: func() {}}
+	if timeout > 0 {
+		ctx, t.stopTimeout = context.WithTimeout(ctx, timeout)
+	}
+	t.ctx = ctx
+	return t
+}
+
+// startFirstByteTimer must be followed by stopFirstByteTimer when headers arrive
+func (t *transfer) startFirstByteTimer() {
+	if t.limits.FirstByteTimeout == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.firstByte = time.AfterFunc(t.limits.FirstByteTimeout, func() {
+		t.cancel(ErrFirstByteTimeout)
+	})
+}
+
+func (t *transfer) stopFirstByteTimer() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.firstByte != nil {
+		t.firstByte.Stop()
+	}
+}
+
+func (t *transfer) startWatchdog() {
+	if !t.limits.watchBody() {
+		return
+	}
+	now := time.Now()
+	t.lastRead = now
+	t.samples = []transferSample{{at: now}}
+	t.watchdogStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(t.limits.tick())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.watchdogStop:
+				return
+			case now := <-ticker.C:
+				if err := t.check(now); err != nil {
+					t.cancel(err)
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (t *transfer) check(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limits.IdleReadTimeout > 0 && now.Sub(t.lastRead) > t.limits.IdleReadTimeout {
+		return ErrIdleRead
+	}
+	if t.limits.MinBytesPerSecond == 0 || t.limits.StallPeriod == 0 {
+		return nil
+	}
+	t.samples = append(t.samples, transferSample{at: now, total: t.total})
+	// keep only one sample older than stall period: rate is measured from it
+	for len(t.samples) > 1 && now.Sub(t.samples[1].at) >= t.limits.StallPeriod {
+		t.samples = t.samples[1:]
+	}
+	oldest := t.samples[0]
+	elapsed := now.Sub(oldest.at)
+	if elapsed < t.limits.StallPeriod {
+		return nil
+	}
+	rate := float64(t.total-oldest.total) / elapsed.Seconds()
+	if rate < float64(t.limits.MinBytesPerSecond) {
+		return fmt.Errorf("%w: %.0f bytes/s for %s", ErrStalled, rate, elapsed.Truncate(time.Millisecond))
+	}
+	return nil
+}
+
+func (t *transfer) account(n int) {
+	if n <= 0 || t.watchdogStop == nil {
+		return
+	}
+	t.mu.Lock()
+	t.total += uint64(n)
+	t.lastRead = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *transfer) stopWatchdog() {
+	t.watchdogOnce.Do(func() {
+		if t.watchdogStop != nil {
+			close(t.watchdogStop)
+		}
+	})
+}
+
+// uploadDone switches from watching request body to waiting for response
+func (t *transfer) uploadDone() {
+	t.stopWatchdog()
+	t.startFirstByteTimer()
+}
+
+func (t *transfer) stop() {
+	t.stopOnce.Do(func() {
+		t.stopFirstByteTimer()
+		t.stopWatchdog()
+		t.stopTimeout()
+		t.cancel(context.Canceled)
+	})
+}
+
+// explain replaces generic "context canceled" by reason transfer was aborted,
+// original error is still reachable by errors.Is and errors.As
+func (t *transfer) explain(err error) error {
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(t.ctx); cause != nil && cause != context.Canceled && !errors.Is(err, cause) {
+		return fmt.Errorf("%w (%w)", cause, err)
+	}
+	return err
+}
+
+// watchedReader feeds watchdog. Response body stops transfer on Close, request
+// body is closed by transport before response arrives so it must not
+type watchedReader struct {
+	io.ReadCloser
+	transfer    *transfer
+	onEOF       func()
+	stopOnClose bool
+}
+
+func (r *watchedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.transfer.account(n)
+	if err == io.EOF && r.onEOF != nil {
+		r.onEOF()
+		r.onEOF = nil
+	}
+	if err != nil && err != io.EOF {
+		err = r.transfer.explain(err)
+	}
+	return n, err
+}
+
+func (r *watchedReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.stopOnClose {
+		r.transfer.stop()
+	}
+	return err
+}
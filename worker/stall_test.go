@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NewMockHTTPServerTrickle sends headers after delay, then body in chunks with pause between them
+func NewMockHTTPServerTrickle(headerDelay time.Duration, chunks int, pause time.Duration) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		time.Sleep(headerDelay)
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < chunks; i++ {
+			fmt.Fprint(w, "X")
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(pause):
+			}
+		}
+	}))
+}
+
+// slowReader returns one byte per pause
+type slowReader struct {
+	left  int
+	pause time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.pause)
+	r.left--
+	p[0] = 'X'
+	return 1, nil
+}
+
+func TestTransferCheckRate(t *testing.T) {
+	start := time.Now()
+	tr := newTransfer(0, TransferLimits{MinBytesPerSecond: 100, StallPeriod: time.Second})
+	tr.watchdogStop = make(chan struct{})
+	tr.lastRead = start
+	tr.samples = []transferSample{{at: start}}
+
+	tr.account(50)
+	assert.NoError(t, tr.check(start.Add(500*time.Millisecond)), "period not passed yet")
+	tr.account(60)
+	assert.NoError(t, tr.check(start.Add(time.Second)), "110 bytes per second is fast enough")
+	assert.ErrorIs(t, tr.check(start.Add(2*time.Second)), ErrStalled, "nothing read during last second")
+}
+
+func TestTransferExplainKeepsTransportError(t *testing.T) {
+	tr := newTransfer(0, TransferLimits{})
+	defer tr.stop()
+	tr.cancel(ErrIdleRead)
+	opErr := &net.OpError{Op: "read", Net: "tcp", Err: context.Canceled}
+	err := tr.explain(opErr)
+	assert.ErrorIs(t, err, ErrIdleRead, "reason of abort")
+	var unwrapped *net.OpError
+	if assert.ErrorAs(t, err, &unwrapped, "transport error reachable") {
+		assert.Equal(t, opErr, unwrapped, "same transport error")
+	}
+	assert.ErrorIs(t, err, context.Canceled, "transport error cause reachable")
+}
+
+func TestRequestBackupBody_Stalled(t *testing.T) {
+	server := NewMockHTTPServerTrickle(0, 100, 20*time.Millisecond)
+	defer server.Close()
+
+	backup := BackupClient{
+		BackupUrlPrefix: server.URL + "/backup/",
+		Client:          server.Client(),
+		Limits:          TransferLimits{MinBytesPerSecond: 1000, StallPeriod: 100 * time.Millisecond},
+	}
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	if assert.NoError(t, err, "headers received") {
+		defer body.Close()
+		_, err = ioutil.ReadAll(body)
+		assert.ErrorIs(t, err, ErrStalled, "slow body aborted")
+	}
+}
+
+func TestRequestBackupBody_IdleRead(t *testing.T) {
+	server := NewMockHTTPServerTrickle(0, 2, time.Second)
+	defer server.Close()
+
+	backup := BackupClient{
+		BackupUrlPrefix: server.URL + "/backup/",
+		Client:          server.Client(),
+		Limits:          TransferLimits{IdleReadTimeout: 50 * time.Millisecond},
+	}
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	if assert.NoError(t, err, "headers received") {
+		defer body.Close()
+		started := time.Now()
+		_, err = ioutil.ReadAll(body)
+		assert.ErrorIs(t, err, ErrIdleRead, "idle body aborted")
+		assert.Less(t, int64(time.Since(started)), int64(time.Second), "aborted before server resumed")
+	}
+}
+
+func TestRequestBackupBody_FirstByteTimeout(t *testing.T) {
+	server := NewMockHTTPServerTrickle(100*time.Millisecond, 1, 0)
+	defer server.Close()
+
+	backup := BackupClient{
+		BackupUrlPrefix: server.URL + "/backup/",
+		Client:          server.Client(),
+		Limits:          TransferLimits{FirstByteTimeout: 20 * time.Millisecond},
+	}
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	assert.Nil(t, body, "body is nil when error")
+	assert.ErrorIs(t, err, ErrFirstByteTimeout, "no headers in time")
+	assert.True(t, IsRetryable(err), "timeout is retryable")
+}
+
+func TestRequestBackupBody_FastBodyNotStalled(t *testing.T) {
+	server := NewMockHTTPServerTrickle(0, 10, time.Millisecond)
+	defer server.Close()
+
+	backup := BackupClient{
+		BackupUrlPrefix: server.URL + "/backup/",
+		Client:          server.Client(),
+		Limits: TransferLimits{
+			FirstByteTimeout:  time.Second,
+			IdleReadTimeout:   time.Second,
+			MinBytesPerSecond: 1,
+			StallPeriod:       time.Second,
+		},
+	}
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	if assert.NoError(t, err, "headers received") {
+		data, err := ioutil.ReadAll(body)
+		assert.NoError(t, err, "body read")
+		assert.Equal(t, strings.Repeat("X", 10), string(data), "whole body read")
+		body.Close()
+	}
+}
+
+func TestRequestAmazon_FirstByteTimerStartsAfterUpload(t *testing.T) {
+	server := NewMockHTTPServerTrickle(0, 0, 0)
+	defer server.Close()
+
+	amazon := AmazonRestorer{
+		UrlPrefix: server.URL,
+		Client:    server.Client(),
+		Limits:    TransferLimits{FirstByteTimeout: 50 * time.Millisecond},
+	}
+	// upload takes longer than first byte timeout
+	body := ioutil.NopCloser(&slowReader{left: 5, pause: 20 * time.Millisecond})
+	err := amazon.PutObjectFromReader(FileIDSuccess, body)
+	assert.NoError(t, err, "slow upload is not a first byte timeout")
+}
+
+func TestRequestAmazon_FirstByteTimeout(t *testing.T) {
+	server := NewMockHTTPServerTrickle(100*time.Millisecond, 0, 0)
+	defer server.Close()
+
+	amazon := AmazonRestorer{
+		UrlPrefix: server.URL,
+		Client:    server.Client(),
+		Limits:    TransferLimits{FirstByteTimeout: 20 * time.Millisecond},
+	}
+	body := ioutil.NopCloser(strings.NewReader(ExpectedFileContent))
+	err := amazon.PutObjectFromReader(FileIDSuccess, body)
+	assert.ErrorIs(t, err, ErrFirstByteTimeout, "no response in time")
+}
+
+func TestRequestAmazon_UploadStalled(t *testing.T) {
+	server := NewMockHTTPServerTrickle(0, 0, 0)
+	defer server.Close()
+
+	amazon := AmazonRestorer{
+		UrlPrefix: server.URL,
+		Client:    server.Client(),
+		Limits:    TransferLimits{IdleReadTimeout: 30 * time.Millisecond},
+	}
+	body := ioutil.NopCloser(&slowReader{left: 3, pause: 200 * time.Millisecond})
+	err := amazon.PutObjectFromReader(FileIDSuccess, body)
+	assert.ErrorIs(t, err, ErrIdleRead, "upload source too slow")
+}
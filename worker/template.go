@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var templateHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+type templatePart struct {
+	literal    string
+	name       string // id, md5, sha1, sha256 or regex group
+	group      int    // index of regex group, -1 for other placeholders
+	start, end int    // hex digits of hash, end 0 means all
+}
+
+// KeyTemplate maps file id to backup url or key. Placeholders:
+//
+//	{id}           file id
+//	{md5:2}        first 2 hex digits of md5 of file id, also sha1 and sha256
+//	{md5:2:4}      hex digits from 2 to 4, for ab/cd/ directory layouts
+//	{1}, {name}    group of Pattern matched against file id
+//
+// Everything else is copied as is
+type KeyTemplate struct {
+	Template string
+	Pattern  *regexp.Regexp // splits file id into groups, nil when template uses none
+	parts    []templatePart
+}
+
+func ParseKeyTemplate(template string, pattern *regexp.Regexp) (*KeyTemplate, error) {
+	tmpl := &KeyTemplate{Template: template, Pattern: pattern}
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			tmpl.parts = append(tmpl.parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			tmpl.parts = append(tmpl.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed {", template)
+		}
+		part, err := tmpl.placeholder(rest[open+1 : open+end])
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", template, err)
+		}
+		tmpl.parts = append(tmpl.parts, part)
+		rest = rest[open+end+1:]
+	}
+	return tmpl, nil
+}
+
+func (tmpl *KeyTemplate) placeholder(spec string) (templatePart, error) {
+	fields := strings.Split(spec, ":")
+	part := templatePart{name: fields[0], group: -1}
+	if _, ok := templateHashes[part.name]; ok {
+		if len(fields) > 3 {
+			return part, fmt.Errorf("{%s}: expect {%s}, {%s:end} or {%s:start:end}", spec, part.name, part.name, part.name)
+		}
+		bounds := make([]int, 0, 2)
+		for _, field := range fields[1:] {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 {
+				return part, fmt.Errorf("{%s}: bad digit position %q", spec, field)
+			}
+			bounds = append(bounds, n)
+		}
+		switch len(bounds) {
+		case 1:
+			part.end = bounds[0]
+		case 2:
+			part.start, part.end = bounds[0], bounds[1]
+		}
+		if len(bounds) > 0 && part.end <= part.start {
+			return part, fmt.Errorf("{%s}: empty digit range", spec)
+		}
+		return part, nil
+	}
+	if len(fields) > 1 {
+		return part, fmt.Errorf("{%s}: only hash placeholders take digit positions", spec)
+	}
+	if part.name == "id" {
+		return part, nil
+	}
+	if tmpl.Pattern == nil {
+		return part, fmt.Errorf("{%s}: unknown placeholder, groups need id pattern", spec)
+	}
+	if n, err := strconv.Atoi(part.name); err == nil {
+		if n < 1 || n > tmpl.Pattern.NumSubexp() {
+			return part, fmt.Errorf("{%s}: pattern %s has %d groups", spec, tmpl.Pattern, tmpl.Pattern.NumSubexp())
+		}
+		part.group = n
+		return part, nil
+	}
+	if part.group = tmpl.Pattern.SubexpIndex(part.name); part.group < 0 {
+		return part, fmt.Errorf("{%s}: no such group in pattern %s", spec, tmpl.Pattern)
+	}
+	return part, nil
+}
+
+// Expand fills template for file id, escape is applied to every substituted
+// value: EscapeKey for urls, nil for keys
+func (tmpl *KeyTemplate) Expand(file_id string, escape func(string) string) (string, error) {
+	var groups []string
+	var expanded strings.Builder
+	for _, part := range tmpl.parts {
+		if part.name == "" {
+			expanded.WriteString(part.literal)
+			continue
+		}
+		var value string
+		switch {
+		case part.name == "id":
+			value = file_id
+		case part.group >= 0:
+			if groups == nil {
+				if groups = tmpl.Pattern.FindStringSubmatch(file_id); groups == nil {
+					return "", fmt.Errorf("file id %q does not match %s", file_id, tmpl.Pattern)
+				}
+			}
+			value = groups[part.group]
+		default:
+			h := templateHashes[part.name]()
+			h.Write([]byte(file_id))
+			value = hex.EncodeToString(h.Sum(nil))
+			if part.end > 0 {
+				if part.end > len(value) {
+					return "", fmt.Errorf("{%s:%d:%d}: %s has %d hex digits", part.name, part.start, part.end, part.name, len(value))
+				}
+				value = value[part.start:part.end]
+			}
+		}
+		if escape != nil {
+			value = escape(value)
+		}
+		expanded.WriteString(value)
+	}
+	return expanded.String(), nil
+}
+
+// NewPrefixTemplate is prefix + {id}, braces in prefix are not placeholders
+func NewPrefixTemplate(prefix string) *KeyTemplate {
+	return &KeyTemplate{Template: prefix + "{id}", parts: []templatePart{{literal: prefix}, {name: "id", group: -1}}}
+}
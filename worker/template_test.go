@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTemplateExpand(t *testing.T) {
+	// md5("abc") = 900150983cd24fb0d6963f7d28e17f72
+	pattern := regexp.MustCompile(`^(?P<user>[0-9]+)-(?P<file>.+)$`)
+	tests := []struct {
+		Template string
+		Id       string
+		Escape   func(string) string
+		Want     string
+	}{
+		{Template: "https://cloud.i/backup/{id}/", Id: "a b", Escape: EscapeKey, Want: "https://cloud.i/backup/a%20b/"},
+		{Template: "{md5:2}/{md5:2:4}/{id}", Id: "abc", Want: "90/01/abc"},
+		{Template: "{md5}", Id: "abc", Want: "900150983cd24fb0d6963f7d28e17f72"},
+		{Template: "{sha1:4}/{sha256:4}", Id: "abc", Want: "a999/ba78"},
+		{Template: "users/{user}/{file}", Id: "42-photo 1.jpg", Want: "users/42/photo 1.jpg"},
+		{Template: "users/{1}/{2}", Id: "42-photo 1.jpg", Escape: EscapeKey, Want: "users/42/photo%201.jpg"},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseKeyTemplate(test.Template, pattern)
+		require.NoError(t, err, "parse %s", test.Template)
+		got, err := tmpl.Expand(test.Id, test.Escape)
+		if assert.NoError(t, err, "expand %s", test.Template) {
+			assert.Equal(t, test.Want, got, "expand %s with %s", test.Template, test.Id)
+		}
+	}
+
+	tmpl, err := ParseKeyTemplate("users/{user}/{file}", pattern)
+	require.NoError(t, err, "parse")
+	_, err = tmpl.Expand("no-user", nil)
+	assert.Error(t, err, "id not matching pattern")
+
+	tmpl, err = ParseKeyTemplate("{md5:30:40}", nil)
+	require.NoError(t, err, "parse")
+	_, err = tmpl.Expand("abc", nil)
+	assert.Error(t, err, "md5 is 32 digits")
+
+	prefix := NewPrefixTemplate("https://cloud.i/{x}/")
+	got, err := prefix.Expand("a", EscapeKey)
+	assert.NoError(t, err, "prefix template")
+	assert.Equal(t, "https://cloud.i/{x}/a", got, "prefix kept as is")
+}
+
+func TestKeyTemplateParseErrors(t *testing.T) {
+	pattern := regexp.MustCompile(`^(\d+)-(?P<file>.+)$`)
+	for _, template := range []string{"{id", "{unknown}", "{3}", "{0}", "{md5:x}", "{md5:4:2}", "{md5:1:2:3}", "{id:2}"} {
+		_, err := ParseKeyTemplate(template, pattern)
+		assert.Error(t, err, "bad template %s", template)
+	}
+	_, err := ParseKeyTemplate("{file}", nil)
+	assert.Error(t, err, "groups without pattern")
+}
+
+func TestBackupMirrorTemplates(t *testing.T) {
+	mirrors, err := NewBackupMirrorTemplates([]string{"https://a/backup/", "https://b/{md5:2}/{id}/"}, nil)
+	require.NoError(t, err, "mirrors")
+	Url, err := mirrors[0].Url("abc")
+	assert.NoError(t, err, "prefix mirror")
+	assert.Equal(t, "https://a/backup/abc", Url, "prefix mirror")
+	Url, err = mirrors[1].Url("abc")
+	assert.NoError(t, err, "template mirror")
+	assert.Equal(t, "https://b/90/abc/", Url, "template mirror")
+
+	_, err = NewBackupMirrorTemplates([]string{"https://b/{bad}/"}, nil)
+	assert.Error(t, err, "bad mirror template")
+}
+
+func TestUploadUrlTemplate(t *testing.T) {
+	tmpl, err := ParseKeyTemplate("{md5:2}/{id}", nil)
+	require.NoError(t, err, "parse")
+	amazon := &AmazonRestorer{UrlPrefix: "https://cloud.i/amazon/", KeyTemplate: tmpl}
+	Url, err := amazon.UploadUrl("a b")
+	assert.NoError(t, err, "upload url")
+	assert.Equal(t, "https://cloud.i/amazon/0c/a%20b", Url, "no double slash, key escaped")
+}
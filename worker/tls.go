@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"":    0,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig describes trust and client identity for one endpoint
+type TLSConfig struct {
+	CAFile     string // PEM bundle trusted in addition to system roots
+	CertFile   string // client certificate, reloaded when file changes
+	KeyFile    string
+	MinVersion string // "1.2", "1.3"
+	// InsecureSkipVerifyTestingOnly disables server certificate check. Never use it in production
+	InsecureSkipVerifyTestingOnly bool
+}
+
+func (config TLSConfig) IsZero() bool {
+	return config == TLSConfig{}
+}
+
+func (config TLSConfig) ClientConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[config.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q, expect 1.0, 1.1, 1.2 or 1.3", config.MinVersion)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		InsecureSkipVerify: config.InsecureSkipVerifyTestingOnly,
+	}
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s: no certificates found", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		reloader, err := NewCertReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return tlsConfig, nil
+}
+
+// CertReloader reads client certificate again when cert or key file
+// modification time changes. If new files are broken, previous certificate
+// is kept. Broken files are parsed and logged once per modification time,
+// not on every handshake
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	failed      error // load error of files modified at failedCert and failedKey
+	failedCert  time.Time
+	failedKey   time.Time
+	logged      string // last error logged
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *CertReloader) modTimes() (time.Time, time.Time, error) {
+	certStat, err := os.Stat(reloader.CertFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("client certificate: %w", err)
+	}
+	keyStat, err := os.Stat(reloader.KeyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("client key: %w", err)
+	}
+	return certStat.ModTime(), keyStat.ModTime(), nil
+}
+
+func (reloader *CertReloader) reload() error {
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return err
+	}
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	if reloader.cert != nil && certModTime.Equal(reloader.certModTime) && keyModTime.Equal(reloader.keyModTime) {
+		return nil
+	}
+	if reloader.failed != nil && certModTime.Equal(reloader.failedCert) && keyModTime.Equal(reloader.failedKey) {
+		return reloader.failed
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.CertFile, reloader.KeyFile)
+	if err != nil {
+		reloader.failed = fmt.Errorf("client certificate %s: %w", reloader.CertFile, err)
+		reloader.failedCert, reloader.failedKey = certModTime, keyModTime
+		return reloader.failed
+	}
+	reloader.cert, reloader.certModTime, reloader.keyModTime = &cert, certModTime, keyModTime
+	reloader.failed = nil
+	return nil
+}
+
+func (reloader *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	err := reloader.reload()
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	switch {
+	case err == nil:
+		reloader.logged = ""
+	case err.Error() != reloader.logged:
+		reloader.logged = err.Error()
+		log.Printf("[ERR][TLS] keep previous client certificate: %s", err)
+	}
+	return reloader.cert, nil
+}
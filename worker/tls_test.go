@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	DER  []byte
+}
+
+func newTestCert(t *testing.T, serial int64, parent *testCert, template *x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{Cert: cert, Key: key, DER: der}
+}
+
+func (c *testCert) WriteFiles(t *testing.T, certFile, keyFile string) {
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.DER}), 0600))
+	if keyFile != "" {
+		keyDER, err := x509.MarshalECPrivateKey(c.Key)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	}
+}
+
+type testPKI struct {
+	Dir        string
+	CA         *testCert
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerCert tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	pki := &testPKI{
+		Dir:      dir,
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	}
+	pki.CA = newTestCert(t, 1, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "private CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	pki.CA.WriteFiles(t, pki.CAFile, "")
+	server := newTestCert(t, 2, pki.CA, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "backup"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	pki.ServerCert = tls.Certificate{Certificate: [][]byte{server.DER}, PrivateKey: server.Key}
+	pki.IssueClientCert(t, 3)
+	return pki
+}
+
+func (pki *testPKI) IssueClientCert(t *testing.T, serial int64) {
+	client := newTestCert(t, serial, pki.CA, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "unfuckup"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	client.WriteFiles(t, pki.CertFile, pki.KeyFile)
+	// make sure modification time differs from previous certificate
+	later := time.Now().Add(time.Duration(serial) * time.Second)
+	os.Chtimes(pki.CertFile, later, later)
+	os.Chtimes(pki.KeyFile, later, later)
+}
+
+// NewMockHTTPServerMTLS requires client certificate signed by private CA and
+// reports serial number of certificate client presented
+func NewMockHTTPServerMTLS(pki *testPKI, serials chan<- int64) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serials <- r.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(pki.CA.Cert)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.ServerCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MaxVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	return server
+}
+
+func getWithTLS(t *testing.T, config TLSConfig, url string) error {
+	tlsConfig, err := config.ClientConfig()
+	require.NoError(t, err, "valid tls config")
+	transport := TransportConfig{TLSClientConfig: tlsConfig}.NewTransport()
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestTLSMutualAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	serials := make(chan int64, 10)
+	server := NewMockHTTPServerMTLS(pki, serials)
+	defer server.Close()
+
+	err := getWithTLS(t, TLSConfig{CAFile: pki.CAFile, CertFile: pki.CertFile, KeyFile: pki.KeyFile}, server.URL)
+	if assert.NoError(t, err, "private CA trusted, client certificate accepted") {
+		assert.Equal(t, int64(3), <-serials, "client certificate presented")
+	}
+
+	err = getWithTLS(t, TLSConfig{CAFile: pki.CAFile}, server.URL)
+	assert.Error(t, err, "no client certificate")
+
+	err = getWithTLS(t, TLSConfig{CertFile: pki.CertFile, KeyFile: pki.KeyFile}, server.URL)
+	assert.Error(t, err, "private CA not trusted")
+
+	err = getWithTLS(t, TLSConfig{CertFile: pki.CertFile, KeyFile: pki.KeyFile, InsecureSkipVerifyTestingOnly: true}, server.URL)
+	if assert.NoError(t, err, "insecure mode skips server verification") {
+		<-serials
+	}
+
+	err = getWithTLS(t, TLSConfig{CAFile: pki.CAFile, CertFile: pki.CertFile, KeyFile: pki.KeyFile, MinVersion: "1.3"}, server.URL)
+	assert.Error(t, err, "server supports TLS 1.2 only")
+}
+
+func TestTLSClientCertReload(t *testing.T) {
+	pki := newTestPKI(t)
+	serials := make(chan int64, 10)
+	server := NewMockHTTPServerMTLS(pki, serials)
+	defer server.Close()
+
+	tlsConfig, err := TLSConfig{CAFile: pki.CAFile, CertFile: pki.CertFile, KeyFile: pki.KeyFile}.ClientConfig()
+	require.NoError(t, err, "valid tls config")
+	transport := TransportConfig{TLSClientConfig: tlsConfig}.NewTransport()
+	client := &http.Client{Transport: transport}
+
+	for _, serial := range []int64{3, 4} {
+		if serial != 3 {
+			pki.IssueClientCert(t, serial)
+		}
+		transport.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if assert.NoError(t, err, "request with certificate %d", serial) {
+			resp.Body.Close()
+			assert.Equal(t, serial, <-serials, "renewed certificate used")
+		}
+	}
+
+	// broken files: previous certificate kept, error logged once
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	require.NoError(t, ioutil.WriteFile(pki.CertFile, []byte("garbage"), 0600))
+	for i := 0; i < 3; i++ {
+		transport.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if assert.NoError(t, err, "previous certificate kept when new one is broken") {
+			resp.Body.Close()
+			assert.Equal(t, int64(4), <-serials, "previous certificate used")
+		}
+	}
+	assert.Equal(t, 1, strings.Count(logs.String(), "[ERR][TLS]"), "broken certificate logged once, not per handshake")
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	_, err := TLSConfig{MinVersion: "2.0"}.ClientConfig()
+	assert.Error(t, err, "unknown TLS version")
+	_, err = TLSConfig{CAFile: "/no/such/file"}.ClientConfig()
+	assert.Error(t, err, "missing CA bundle")
+	_, err = TLSConfig{CertFile: "/no/such/cert", KeyFile: "/no/such/key"}.ClientConfig()
+	assert.Error(t, err, "missing client certificate")
+	assert.True(t, TLSConfig{}.IsZero(), "empty config")
+}
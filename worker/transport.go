@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig holds connection pool settings. Default http.Transport keeps
+// only 2 idle connections per host, which is far too few for MaxParallel
+// workers talking to the same host
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	KeepAlive             time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	HTTP2                 bool
+	TLSClientConfig       *tls.Config
+}
+
+func (config TransportConfig) NewTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ForceAttemptHTTP2:     config.HTTP2,
+	}
+	if config.TLSClientConfig != nil {
+		transport.TLSClientConfig = config.TLSClientConfig.Clone()
+	}
+	if !config.HTTP2 {
+		// non-nil empty map disables HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+func (config TransportConfig) NewClient() *http.Client {
+	return &http.Client{Transport: config.NewTransport()}
+}
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NewMockHTTPServerCountConn is backup mock server counting accepted
+// connections, it answers after delay so concurrent requests overlap
+func NewMockHTTPServerCountConn(connCount *uint64, delay time.Duration) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backup/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		fmt.Fprint(w, ExpectedFileContent)
+	})
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddUint64(connCount, 1)
+		}
+	}
+	server.StartTLS()
+	return server
+}
+
+func TunedTransportFor(server *httptest.Server, parallel int) TransportConfig {
+	return TransportConfig{
+		MaxIdleConnsPerHost: parallel,
+		IdleConnTimeout:     time.Minute,
+		KeepAlive:           30 * time.Second,
+		DialTimeout:         10 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     server.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+}
+
+func fetchAndClose(t testing.TB, backup *BackupClient) {
+	body, err := backup.RequestBackupBody(FileIDSuccess)
+	if err != nil {
+		t.Errorf("request error: %s", err)
+		return
+	}
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+}
+
+// fetchRounds sends rounds of parallel requests, every round after previous
+// one finished, and returns connections server accepted
+func fetchRounds(t *testing.T, client func(*httptest.Server) *http.Client, parallel, rounds int) uint64 {
+	var connCount uint64
+	server := NewMockHTTPServerCountConn(&connCount, 20*time.Millisecond)
+	defer server.Close()
+
+	backup := &BackupClient{BackupUrlPrefix: server.URL + "/backup/", Client: client(server)}
+	for round := 0; round < rounds; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < parallel; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fetchAndClose(t, backup)
+			}()
+		}
+		wg.Wait()
+	}
+	return atomic.LoadUint64(&connCount)
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	parallel, rounds := 20, 5
+	tuned := fetchRounds(t, func(server *httptest.Server) *http.Client {
+		return TunedTransportFor(server, parallel).NewClient()
+	}, parallel, rounds)
+	assert.Equal(t, uint64(parallel), tuned, "connections of first round reused by next rounds")
+
+	untuned := fetchRounds(t, func(server *httptest.Server) *http.Client {
+		return server.Client()
+	}, parallel, rounds)
+	assert.Greater(t, untuned, uint64(parallel), "default transport keeps 2 idle connections and dials again")
+}
+
+func TestTransportHTTP2Switch(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	for _, enabled := range []bool{true, false} {
+		config := TunedTransportFor(server, 1)
+		config.HTTP2 = enabled
+		resp, err := config.NewClient().Get(server.URL)
+		if assert.NoError(t, err, "request with http2=%v", enabled) {
+			assert.Equal(t, enabled, resp.ProtoMajor == 2, "http2=%v", enabled)
+			resp.Body.Close()
+		}
+	}
+}
+
+func benchmarkBackupConnections(b *testing.B, client func(*httptest.Server) *http.Client) {
+	var connCount uint64
+	server := NewMockHTTPServerCountConn(&connCount, 0)
+	defer server.Close()
+
+	backup := &BackupClient{BackupUrlPrefix: server.URL + "/backup/", Client: client(server)}
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fetchAndClose(b, backup)
+		}
+	})
+	b.ReportMetric(float64(atomic.LoadUint64(&connCount)), "conns")
+}
+
+// go test -run - -bench Connections -cpu 4 ./worker/ shows conns opened by each
+// client: default transport keeps 2 idle conns and dials again and again
+func BenchmarkBackupConnectionsDefaultTransport(b *testing.B) {
+	benchmarkBackupConnections(b, func(server *httptest.Server) *http.Client {
+		return server.Client()
+	})
+}
+
+func BenchmarkBackupConnectionsTunedTransport(b *testing.B) {
+	benchmarkBackupConnections(b, func(server *httptest.Server) *http.Client {
+		return TunedTransportFor(server, 16*runtime.GOMAXPROCS(0)).NewClient()
+	})
+}
@@ -1,11 +1,17 @@
 package worker
 
+import "time"
+
 type WorkerCallback func(WorkerTask) error
 
 type WorkerTask struct {
-	Line      uint64
-	Id        string
-	FailCount uint32
+	Line        uint64
+	Id          string
+	Source      string // input file Line belongs to
+	Seq         uint64 // record position in input, see generator.Progress
+	Destination string // name of restore destination picked by Router
+	FailCount   uint32
+	NotBefore   time.Time // retry should not start earlier, zero for new tasks; see DelayQueue
 }
 
 type WorkResult struct {
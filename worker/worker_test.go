@@ -55,3 +55,33 @@ func TestOneTaskFail(t *testing.T) {
 	w.StopAsync()
 	<-w.RipChannel
 }
+
+func TestDelayQueue(t *testing.T) {
+	var queue DelayQueue
+	assert.Nil(t, queue.C(), "empty queue never fires")
+	now := time.Now()
+	for i, delay := range []time.Duration{40 * time.Millisecond, 10 * time.Millisecond, time.Hour} {
+		task := ValidTask
+		task.Line = uint64(i + 1)
+		task.NotBefore = now.Add(delay)
+		queue.Push(task)
+	}
+	assert.Empty(t, queue.Due(now), "nothing due yet")
+
+	due := make([]uint64, 0)
+	for len(due) < 2 {
+		select {
+		case fired := <-queue.C():
+			for _, task := range queue.Due(fired) {
+				assert.False(t, fired.Before(task.NotBefore), "task %d not due before NotBefore", task.Line)
+				due = append(due, task.Line)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("queue timer did not fire")
+		}
+	}
+	assert.Equal(t, []uint64{2, 1}, due, "tasks come out in NotBefore order")
+	assert.Equal(t, 1, queue.Len(), "late task waits")
+	assert.Len(t, queue.Drain(), 1, "drain returns waiting task")
+	assert.Nil(t, queue.C(), "drained queue never fires")
+}